@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// cache 的子命令
+var cacheCommands = map[string]func(args []string){
+	"ls":     runCacheLs,
+	"du":     runCacheDu,
+	"prune":  runCachePrune,
+	"verify": runCacheVerify,
+	"export": runCacheExport,
+	"import": runCacheImport,
+}
+
+// runCache 管理 cache 目录: ls, du, prune, verify
+func runCache(args []string) {
+	if len(args) == 0 {
+		fatalUsage("用法: docker-pull cache ls|du|prune|verify|export|import [参数]")
+	}
+	run, ok := cacheCommands[args[0]]
+	if !ok {
+		fatalUsage("未知的 cache 子命令: %s, 可选 ls, du, prune, verify, export, import", args[0])
+	}
+	run(args[1:])
+}
+
+// newCacheFlagSet 所有 cache 子命令都有 -cache-dir 参数
+func newCacheFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("cache "+name, flag.ExitOnError)
+	cacheDir := fs.String("cache-dir", dockerpull.DefaultCacheDir(), "缓存目录, 同下载的 -cache-dir")
+	return fs, cacheDir
+}
+
+func runCacheLs(args []string) {
+	fs, cacheDir := newCacheFlagSet("ls")
+	output := fs.String("output", "output", "根据这个目录下的 tar 元数据显示引用 blob 的镜像")
+	format := fs.String("format", "table", "输出格式, 可选 table, json")
+	fs.Parse(args)
+
+	blobs, err := dockerpull.ListCache(*cacheDir, *output)
+	if err != nil {
+		fatalError(fmt.Errorf("failed to list cache: %w", err))
+	}
+
+	switch *format {
+	case "json":
+		writeJSON(blobs)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tDIGEST\tSIZE\tLAST USED\tIMAGES")
+		for _, blob := range blobs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", blob.Kind, truncate(blob.Digest, 19), dockerpull.FormatSize(blob.Size),
+				blob.LastUsed.Format(time.DateTime), formatImages(blob.Images))
+		}
+		w.Flush()
+	default:
+		fatalUsage("Unsupported format: %s", *format)
+	}
+}
+
+func runCacheDu(args []string) {
+	fs, cacheDir := newCacheFlagSet("du")
+	format := fs.String("format", "table", "输出格式, 可选 table, json")
+	fs.Parse(args)
+
+	usage, err := dockerpull.DiskUsage(*cacheDir)
+	if err != nil {
+		fatalError(fmt.Errorf("failed to compute cache usage: %w", err))
+	}
+
+	switch *format {
+	case "json":
+		writeJSON(usage)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TYPE\tCOUNT\tSIZE")
+		fmt.Fprintf(w, "layers\t%d\t%s\n", usage.Layers, dockerpull.FormatSize(usage.LayersSize))
+		fmt.Fprintf(w, "config\t%d\t%s\n", usage.Configs, dockerpull.FormatSize(usage.ConfigsSize))
+		fmt.Fprintf(w, "tmp\t\t%s\n", dockerpull.FormatSize(usage.TmpSize))
+		fmt.Fprintf(w, "total\t\t%s\n", dockerpull.FormatSize(usage.Total()))
+		w.Flush()
+		fmt.Println(*cacheDir)
+	default:
+		fatalUsage("Unsupported format: %s", *format)
+	}
+}
+
+func runCachePrune(args []string) {
+	fs, cacheDir := newCacheFlagSet("prune")
+	olderThan := fs.String("older-than", "", "删除超过这个时间没有使用的 blob, 如 72h, 30d")
+	maxSize := fs.String("max-size", "", "总大小超过这个值时, 按最后使用时间从旧到新删除, 如 20G")
+	unreferenced := fs.Bool("unreferenced", false, "删除没有被 -output 目录中任何 tar 引用的 blob")
+	output := fs.String("output", "", "-unreferenced 时检查的输出目录, 必须指定 (cache 被所有目录的下载共用)")
+	dryRun := fs.Bool("dry-run", false, "只显示会删除的 blob, 不删除")
+	fs.Parse(args)
+
+	if *unreferenced && *output == "" {
+		fatalUsage("-unreferenced 需要 -output 参数指定输出目录")
+	}
+
+	opts := dockerpull.PruneOptions{
+		Unreferenced: *unreferenced,
+		OutputDir:    *output,
+		DryRun:       *dryRun,
+	}
+	if *olderThan != "" {
+		age, err := parseAge(*olderThan)
+		if err != nil {
+			fatalUsage("older-than 参数格式错误: %v", err)
+		}
+		opts.OlderThan = age
+	}
+	if *maxSize != "" {
+		size, err := dockerpull.ParseByteSize(*maxSize)
+		if err != nil {
+			fatalUsage("max-size 参数格式错误: %v", err)
+		}
+		opts.MaxSize = size
+	}
+	if opts.OlderThan == 0 && opts.MaxSize == 0 && !opts.Unreferenced {
+		fatalUsage("至少需要 -older-than, -max-size, -unreferenced 中的一个")
+	}
+
+	removed, err := dockerpull.PruneCache(*cacheDir, opts)
+	if err != nil {
+		fatalError(fmt.Errorf("failed to prune cache: %w", err))
+	}
+
+	action := "Removed"
+	if *dryRun {
+		action = "Would remove"
+	}
+	var freed int64
+	for _, blob := range removed {
+		fmt.Printf("%s %s %s (%s)\n", action, blob.Kind, blob.Digest, dockerpull.FormatSize(blob.Size))
+		freed += blob.Size
+	}
+	fmt.Printf("%s %d blobs, %s\n", action, len(removed), dockerpull.FormatSize(freed))
+}
+
+// runCacheVerify 有损坏的 blob 并且没有删除时 (-dry-run) 退出码为 1
+func runCacheVerify(args []string) {
+	fs, cacheDir := newCacheFlagSet("verify")
+	dryRun := fs.Bool("dry-run", false, "只检查, 不删除损坏的 blob")
+	fs.Parse(args)
+
+	results, err := dockerpull.VerifyCache(*cacheDir, !*dryRun)
+	if err != nil {
+		fatalError(fmt.Errorf("failed to verify cache: %w", err))
+	}
+
+	corrupt := 0
+	for _, result := range results {
+		if result.OK {
+			continue
+		}
+		corrupt++
+		status := "corrupt"
+		if result.Removed {
+			status = "removed"
+		}
+		fmt.Printf("%s\t%s %s (actual %s)\n", status, result.Kind, result.Digest, result.Actual)
+	}
+	fmt.Printf("Verified %d blobs, %d corrupt\n", len(results), corrupt)
+
+	if corrupt > 0 && *dryRun {
+		os.Exit(1)
+	}
+}
+
+// runCacheExport 把镜像需要的 manifest 和 blob 打包, 在另一台机器上 cache import 之后可以 -offline 构建
+func runCacheExport(args []string) {
+	fs, cacheDir := newCacheFlagSet("export")
+	output := fs.String("o", "", "输出的 bundle 文件, - 表示输出到 stdout")
+	arch := fs.String("arch", "amd64", "导出的平台, all 表示 cache 中已有的所有平台")
+	images := parseInterspersed(fs, args)
+
+	if *output == "" || len(images) == 0 {
+		fatalUsage("用法: docker-pull cache export <镜像...> -o bundle.tar")
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatalError(fmt.Errorf("failed to create bundle: %w", err))
+		}
+		defer f.Close()
+		w = f
+	}
+
+	stats, err := dockerpull.ExportBundle(*cacheDir, images, *arch, w)
+	if err != nil {
+		if *output != "-" {
+			os.Remove(*output)
+		}
+		fatalError(err)
+	}
+	Logger.Infof("Exported %d files (%s) to %s", stats.Files, dockerpull.FormatSize(stats.Bytes), *output)
+}
+
+// runCacheImport 校验 bundle 中的 digest 后合并到 cache
+func runCacheImport(args []string) {
+	fs, cacheDir := newCacheFlagSet("import")
+	files := parseInterspersed(fs, args)
+
+	if len(files) != 1 {
+		fatalUsage("用法: docker-pull cache import bundle.tar, - 表示从 stdin 读取")
+	}
+
+	var r io.Reader = os.Stdin
+	if files[0] != "-" {
+		f, err := os.Open(files[0])
+		if err != nil {
+			fatalError(fmt.Errorf("failed to open bundle: %w", err))
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stats, err := dockerpull.ImportBundle(*cacheDir, r)
+	Logger.Infof("Imported %d files (%s), %d already in cache", stats.Files, dockerpull.FormatSize(stats.Bytes), stats.Skipped)
+	if err != nil {
+		fatalError(err)
+	}
+}
+
+// parseInterspersed 解析参数, 允许参数和位置参数混在一起, 如 cache export nginx:1.25 -o bundle.tar
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// formatImages 没有被引用时显示 -
+func formatImages(images []string) string {
+	if len(images) == 0 {
+		return "-"
+	}
+	return strings.Join(images, ", ")
+}
+
+// parseAge 解析时间, 除了 time.ParseDuration 的格式外还支持天, 如 30d
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func writeJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fatalError(err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// runCheck 只检查 output 下的 tar 是否过期 (远程 manifest digest 已变化), 不会重新构建
+//
+// 有过期或检查失败的 tar 时退出码为 1
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+
+	var proxyAddr string
+	fs.StringVar(&proxyAddr, "proxy", "", "代理地址, 格式同下载")
+	fs.Parse(args)
+
+	// 可以只检查指定的镜像
+	filter := make(map[string]bool)
+	for _, image := range fs.Args() {
+		ref, _, err := dockerpull.ParseImageRef(image)
+		if err != nil {
+			fatalUsage("Failed to parse image %s: %v", image, err)
+		}
+		filter[ref.DockerReference().String()] = true
+	}
+
+	metas, err := dockerpull.ListArchiveMetas("output")
+	if err != nil {
+		fatalError(fmt.Errorf("failed to list archives: %w", err))
+	}
+
+	ctx := context.Background()
+	sysCtx := dockerpull.NewSystemContext(parseProxy(proxyAddr))
+
+	// 同一个镜像有多个 tar 时只查询一次
+	remoteDigests := make(map[string]string)
+
+	stale := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tREFERENCE\tARCH\tARCHIVE")
+
+	for _, metaPath := range metas {
+		meta, err := dockerpull.ReadArchiveMeta(metaPath)
+		if err != nil {
+			Logger.Warnf("Skipping %s: %v", metaPath, err)
+			continue
+		}
+		if len(filter) > 0 && !filter[meta.Reference] {
+			continue
+		}
+
+		archive := strings.TrimSuffix(metaPath, ".json")
+
+		remote, ok := remoteDigests[meta.Reference]
+		if !ok {
+			remote, err = dockerpull.RemoteManifestDigest(ctx, meta.Reference, sysCtx)
+			if err != nil {
+				Logger.Warnf("Failed to resolve %s: %v", meta.Reference, err)
+			}
+			remoteDigests[meta.Reference] = remote
+		}
+
+		status := dockerpull.CheckArchive(metaPath, meta, remote)
+		if status != dockerpull.ArchiveUpToDate {
+			stale = true
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", status, meta.Reference, meta.Arch, archive)
+	}
+	w.Flush()
+
+	if stale {
+		os.Exit(1)
+	}
+}
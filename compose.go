@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// composeImages 读取 compose 文件中的镜像; envFile 为空时使用 compose 文件旁边的 .env (存在时)
+func composeImages(composeFile, envFile string) []string {
+	env := map[string]string{}
+	if envFile == "" {
+		envFile = filepath.Join(filepath.Dir(composeFile), ".env")
+		if !dockerpull.FileExists(envFile) {
+			envFile = ""
+		}
+	}
+	if envFile != "" {
+		var err error
+		if env, err = dockerpull.LoadEnvFile(envFile); err != nil {
+			fatalError(fmt.Errorf("failed to read env file: %w", err))
+		}
+	}
+
+	images, err := dockerpull.ComposeImages(composeFile, env)
+	if err != nil {
+		fatalError(err)
+	}
+	printFoundImages(composeFile, images)
+	return images
+}
+
+// kubernetesImages 收集 Kubernetes manifest 文件或目录中的镜像, - 表示从 stdin 读取 (如 helm template 的输出)
+func kubernetesImages(path string) []string {
+	images, err := dockerpull.KubernetesImages(path, Logger)
+	if err != nil {
+		fatalError(err)
+	}
+	if path == "-" {
+		path = "stdin"
+	}
+	printFoundImages(path, images)
+	return images
+}
+
+// dockerfileImages 解析 Dockerfile 中 FROM 引用的镜像, 返回镜像和对应的架构
+func dockerfileImages(path, arch string, buildArgs map[string]string) ([]string, []string) {
+	found, err := dockerpull.DockerfileImages(path, arch, buildArgs)
+	if err != nil {
+		fatalError(err)
+	}
+
+	var images, archs []string
+	for _, img := range found {
+		images = append(images, img.Image)
+		archs = append(archs, img.Arch)
+	}
+	printFoundImages(path, images)
+	return images, archs
+}
+
+func printFoundImages(source string, images []string) {
+	if len(images) == 0 {
+		fatalError(fmt.Errorf("no images found in %s", source))
+	}
+	color.HiCyan("Found %d images in %s:", len(images), source)
+	for _, img := range images {
+		color.HiCyan("  %s", img)
+	}
+}
+
+// writeBundle 把所有下载的 tar 合并为 bundle, 并在旁边生成校验 sha256 后 docker load 的脚本
+func writeBundle(bundle string, results []dockerpull.Result) {
+	var archives []string
+	for _, r := range results {
+		archives = append(archives, r.Output)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(bundle), 0755); err != nil {
+		fatalError(err)
+	}
+	f, err := os.Create(bundle)
+	if err != nil {
+		fatalError(fmt.Errorf("failed to create bundle: %w", err))
+	}
+	h := sha256.New()
+	err = dockerpull.MergeArchives(io.MultiWriter(f, h), archives)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(bundle)
+		fatalError(err)
+	}
+
+	script := strings.TrimSuffix(bundle, ".tar") + "-load.sh"
+	if err := writeLoadScript(script, filepath.Base(bundle), hex.EncodeToString(h.Sum(nil)), results); err != nil {
+		fatalError(err)
+	}
+	color.HiMagenta("Successfully created bundle: %s (load with %s)", bundle, script)
+}
+
+func writeLoadScript(path, bundle, sum string, results []dockerpull.Result) error {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by docker-pull, loads the following images:\n")
+	for _, r := range results {
+		fmt.Fprintf(&b, "#   %s (%s)\n", r.Reference, r.Platform)
+	}
+	b.WriteString("set -e\n")
+	b.WriteString("cd \"$(dirname \"$0\")\"\n\n")
+	b.WriteString("if command -v sha256sum >/dev/null 2>&1; then\n")
+	fmt.Fprintf(&b, "  echo \"%s  %s\" | sha256sum -c -\n", sum, bundle)
+	b.WriteString("elif command -v shasum >/dev/null 2>&1; then\n")
+	fmt.Fprintf(&b, "  echo \"%s  %s\" | shasum -a 256 -c -\n", sum, bundle)
+	b.WriteString("fi\n\n")
+	fmt.Fprintf(&b, "docker load -i %q\n", bundle)
+
+	if err := os.WriteFile(path, []byte(b.String()), 0755); err != nil {
+		return fmt.Errorf("failed to write load script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containers/image/v5/types"
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// copyFlags copy 和 sync 共用的参数, 源和目标 registry 可以使用不同的鉴权和 TLS 设置
+type copyFlags struct {
+	proxy        *string
+	srcCreds     *string
+	destCreds    *string
+	srcInsecure  *bool
+	destInsecure *bool
+	force        *bool
+	format       *string
+}
+
+func addCopyFlags(fs *flag.FlagSet) copyFlags {
+	return copyFlags{
+		proxy:        fs.String("proxy", "", "代理地址, 格式同下载"),
+		srcCreds:     fs.String("src-creds", "", "源 registry 的用户名和密码, 格式 user:password; 为空时使用 docker login 保存的凭据"),
+		destCreds:    fs.String("dest-creds", "", "目标 registry 的用户名和密码, 格式同 -src-creds"),
+		srcInsecure:  fs.Bool("src-insecure", false, "不校验源 registry 的 TLS 证书"),
+		destInsecure: fs.Bool("dest-insecure", false, "不校验目标 registry 的 TLS 证书"),
+		force:        fs.Bool("force", false, "目标已经是同一个 manifest 时也重新检查并上传"),
+		format:       fs.String("format", "table", "输出格式, 可选 table, json"),
+	}
+}
+
+// validate 在复制之前检查参数, 避免复制完成后才因为参数错误退出
+func (f copyFlags) validate() {
+	if *f.format != "table" && *f.format != "json" {
+		fatalUsage("Unsupported format: %s", *f.format)
+	}
+}
+
+func (f copyFlags) systemContexts() (src, dest *types.SystemContext) {
+	return newSystemContext("src-creds", *f.proxy, *f.srcCreds, *f.srcInsecure),
+		newSystemContext("dest-creds", *f.proxy, *f.destCreds, *f.destInsecure)
+}
+
+// runCopy 把镜像从一个 registry 复制到另一个 registry, 保留 manifest list 和 digest
+func runCopy(args []string) {
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	flags := addCopyFlags(fs)
+	positional := parseInterspersed(fs, args)
+
+	if len(positional) != 2 {
+		fatalUsage("用法: docker-pull copy [参数] <源镜像> <目标镜像>")
+	}
+	flags.validate()
+
+	srcSysCtx, destSysCtx := flags.systemContexts()
+	result, err := dockerpull.Copy(context.Background(), dockerpull.CopyOptions{
+		Source:                   positional[0],
+		Destination:              positional[1],
+		SourceSystemContext:      srcSysCtx,
+		DestinationSystemContext: destSysCtx,
+		Force:                    *flags.force,
+		Logger:                   Logger,
+	})
+	if err != nil {
+		fatalError(fmt.Errorf("failed to copy image: %w", err))
+	}
+	printCopyResults(*flags.format, []dockerpull.CopyResult{result})
+}
+
+// runSync 按 sync.yaml 复制多个镜像, 只上传目标中缺少的 blob
+func runSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	file := fs.String("f", "sync.yaml", "同步配置文件")
+	dryRun := fs.Bool("dry-run", false, "只显示需要复制的镜像, 不复制")
+	flags := addCopyFlags(fs)
+	fs.Parse(args)
+	flags.validate()
+
+	cfg, err := dockerpull.LoadSyncConfig(*file)
+	if err != nil {
+		fatalUsage("%v", err)
+	}
+
+	ctx := context.Background()
+	srcSysCtx, destSysCtx := flags.systemContexts()
+
+	if *dryRun {
+		pairs, err := dockerpull.ResolveSync(ctx, cfg, srcSysCtx)
+		for _, pair := range pairs {
+			fmt.Printf("%s -> %s\n", pair.Source, pair.Destination)
+		}
+		if err != nil {
+			fatalError(err)
+		}
+		return
+	}
+
+	results, err := dockerpull.Sync(ctx, cfg, dockerpull.SyncOptions{
+		SourceSystemContext:      srcSysCtx,
+		DestinationSystemContext: destSysCtx,
+		Force:                    *flags.force,
+		Logger:                   Logger,
+	})
+	printCopyResults(*flags.format, results)
+	if err != nil {
+		fatalError(fmt.Errorf("failed to sync images: %w", err))
+	}
+}
+
+func printCopyResults(format string, results []dockerpull.CopyResult) {
+	switch format {
+	case "json":
+		writeJSON(results)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SOURCE\tDESTINATION\tDIGEST\tPLATFORMS\tUPLOADED\tSKIPPED")
+		for _, r := range results {
+			uploaded := fmt.Sprintf("%d (%s)", r.Uploaded, dockerpull.FormatSize(r.UploadedBytes))
+			if r.UpToDate {
+				uploaded = "up to date"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\n", r.Source, r.Destination, truncate(r.ManifestDigest, 19), r.Platforms, uploaded, r.Skipped)
+		}
+		w.Flush()
+	default:
+		fatalUsage("Unsupported format: %s", format)
+	}
+}
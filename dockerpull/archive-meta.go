@@ -0,0 +1,153 @@
+package dockerpull
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveMeta 输出 tar 旁边的元数据文件 (<tar>.json), 记录构建时远程 manifest 的 digest, 用来判断 tar 是否需要重新构建
+type ArchiveMeta struct {
+	Reference      string        `json:"reference"`
+	Arch           string        `json:"arch"`
+	Platform       string        `json:"platform,omitempty"`
+	Squash         bool          `json:"squash,omitempty"`
+	ManifestDigest string        `json:"manifestDigest"`
+	PlatformDigest string        `json:"platformDigest,omitempty"`
+	ConfigDigest   string        `json:"configDigest"`
+	Layers         []LayerReport `json:"layers,omitempty"`
+	// squash 时合并前的 config digest 和 tar 中实际的 layer (合并后的 layer)
+	SourceConfigDigest string        `json:"sourceConfigDigest,omitempty"`
+	ArchiveLayers      []LayerReport `json:"archiveLayers,omitempty"`
+	Archive            string        `json:"archive"`
+	Sha256             string        `json:"sha256,omitempty"`
+	Size               int64         `json:"size,omitempty"`
+	Created            time.Time     `json:"created"`
+}
+
+// LayerReport 镜像中的一个 layer, Cached 表示本次是否直接使用了 cache 而没有下载
+type LayerReport struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"mediaType,omitempty"`
+	Cached    bool   `json:"cached"`
+}
+
+// archiveMetaPath 返回 tar 对应的元数据文件路径
+func archiveMetaPath(tarFilePath string) string {
+	return tarFilePath + ".json"
+}
+
+func writeArchiveMeta(tarFilePath string, meta ArchiveMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+	if err := writeFileAtomic(archiveMetaPath(tarFilePath), data); err != nil {
+		return fmt.Errorf("failed to write metadata file: %v", err)
+	}
+	return nil
+}
+
+// ReadArchiveMeta 读取 tar 旁边的元数据文件
+func ReadArchiveMeta(metaPath string) (ArchiveMeta, error) {
+	var meta ArchiveMeta
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to unmarshal %s: %v", metaPath, err)
+	}
+	return meta, nil
+}
+
+// ListArchiveMetas 查找 dir 下所有 tar 的元数据文件
+func ListArchiveMetas(dir string) ([]string, error) {
+	var metas []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".tar.json") {
+			metas = append(metas, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return metas, err
+}
+
+// findUpToDateArchive 在镜像的输出目录里查找和远程 manifest digest 一致的 tar, 返回 tar 路径和它的元数据
+func findUpToDateArchive(log Logger, info DockerImageV2, meta ArchiveMeta) (string, ArchiveMeta, bool) {
+	metas, err := ListArchiveMetas(filepath.Join("output", info.Namespace, info.Repository))
+	if err != nil {
+		log.Debugf("Failed to list archive metadata: %v", err)
+		return "", ArchiveMeta{}, false
+	}
+
+	for _, metaPath := range metas {
+		existing, err := ReadArchiveMeta(metaPath)
+		if err != nil {
+			log.Debugf("Skipping %s: %v", metaPath, err)
+			continue
+		}
+
+		if existing.Reference != meta.Reference || existing.Arch != meta.Arch || existing.Squash != meta.Squash {
+			continue
+		}
+		if existing.ManifestDigest != meta.ManifestDigest {
+			continue
+		}
+
+		tarFilePath := strings.TrimSuffix(metaPath, ".json")
+		if archiveComplete(tarFilePath, existing) {
+			return tarFilePath, existing, true
+		}
+	}
+
+	return "", ArchiveMeta{}, false
+}
+
+// archiveComplete tar 存在并且大小和元数据一致; 大小不一致说明 tar 不完整或者被修改过, 需要重新构建
+func archiveComplete(tarFilePath string, meta ArchiveMeta) bool {
+	fi, err := os.Stat(tarFilePath)
+	return err == nil && fi.Mode().IsRegular() && fi.Size() == meta.Size
+}
+
+// ArchiveStatus check 子命令中 tar 的状态
+type ArchiveStatus string
+
+const (
+	ArchiveUpToDate   ArchiveStatus = "up-to-date"
+	ArchiveStale      ArchiveStatus = "stale"
+	ArchiveMissing    ArchiveStatus = "missing"
+	ArchiveIncomplete ArchiveStatus = "incomplete"
+	// ArchiveError 查询远程 manifest digest 失败
+	ArchiveError ArchiveStatus = "error"
+)
+
+// CheckArchive 比较元数据文件对应的 tar 和远程 manifest digest, remoteDigest 为空表示查询失败
+func CheckArchive(metaPath string, meta ArchiveMeta, remoteDigest string) ArchiveStatus {
+	tarFilePath := strings.TrimSuffix(metaPath, ".json")
+	switch {
+	case remoteDigest == "":
+		return ArchiveError
+	case !FileExists(tarFilePath):
+		return ArchiveMissing
+	case !archiveComplete(tarFilePath, meta):
+		return ArchiveIncomplete
+	case remoteDigest != meta.ManifestDigest:
+		return ArchiveStale
+	default:
+		return ArchiveUpToDate
+	}
+}
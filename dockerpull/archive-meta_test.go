@@ -0,0 +1,172 @@
+package dockerpull
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTestArchive 在 output 下写入一个 tar 和它的元数据
+func writeTestArchive(t *testing.T, name string, meta ArchiveMeta) string {
+	t.Helper()
+
+	tarFilePath := filepath.Join("output", "library", "nginx", name)
+	if err := os.MkdirAll(filepath.Dir(tarFilePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tarFilePath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta.Size = 4
+	if err := writeArchiveMeta(tarFilePath, meta); err != nil {
+		t.Fatal(err)
+	}
+	return tarFilePath
+}
+
+func TestFindUpToDateArchive(t *testing.T) {
+	t.Chdir(t.TempDir())
+	info := DockerImageV2{Namespace: "library", Repository: "nginx"}
+	meta := ArchiveMeta{Reference: "docker.io/library/nginx:1.25", Arch: "arm64", ManifestDigest: "sha256:1"}
+	tarFilePath := writeTestArchive(t, "nginx_1.25_arm64.tar", meta)
+
+	changed := func(f func(m *ArchiveMeta)) ArchiveMeta {
+		m := meta
+		f(&m)
+		return m
+	}
+	tests := []struct {
+		name string
+		meta ArchiveMeta
+		want bool
+	}{
+		{"same digest", meta, true},
+		{"manifest changed", changed(func(m *ArchiveMeta) { m.ManifestDigest = "sha256:2" }), false},
+		{"other arch", changed(func(m *ArchiveMeta) { m.Arch = "amd64" }), false},
+		{"squash", changed(func(m *ArchiveMeta) { m.Squash = true }), false},
+		{"other tag", changed(func(m *ArchiveMeta) { m.Reference = "docker.io/library/nginx:1.26" }), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, got, ok := findUpToDateArchive(nopLogger{}, info, tt.meta)
+			if ok != tt.want {
+				t.Fatalf("findUpToDateArchive() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && (path != tarFilePath || got.ManifestDigest != meta.ManifestDigest) {
+				t.Errorf("findUpToDateArchive() = %s, %+v", path, got)
+			}
+		})
+	}
+
+	// 中断的构建留下不完整的 tar, 大小和元数据不一致
+	if err := os.WriteFile(tarFilePath, []byte("da"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := findUpToDateArchive(nopLogger{}, info, meta); ok {
+		t.Error("findUpToDateArchive() of a truncated tar ok = true")
+	}
+
+	if err := os.Remove(tarFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := findUpToDateArchive(nopLogger{}, info, meta); ok {
+		t.Error("findUpToDateArchive() of a missing tar ok = true")
+	}
+}
+
+func TestListArchiveMetas(t *testing.T) {
+	t.Chdir(t.TempDir())
+	a := writeTestArchive(t, "a.tar", ArchiveMeta{})
+	b := writeTestArchive(t, filepath.Join("sub", "b.tar"), ArchiveMeta{})
+
+	metas, err := ListArchiveMetas("output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{archiveMetaPath(a), archiveMetaPath(b)}
+	if !slices.Equal(metas, want) {
+		t.Errorf("ListArchiveMetas() = %v, want %v", metas, want)
+	}
+
+	if metas, err := ListArchiveMetas("missing"); err != nil || metas != nil {
+		t.Errorf("ListArchiveMetas() of a missing directory = %v, %v", metas, err)
+	}
+}
+
+func TestCheckArchive(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta := ArchiveMeta{Reference: "docker.io/library/nginx:1.25", ManifestDigest: "sha256:1", Size: 4}
+	tarFilePath := writeTestArchive(t, "nginx.tar", meta)
+	metaPath := archiveMetaPath(tarFilePath)
+
+	tests := []struct {
+		name   string
+		remote string
+		want   ArchiveStatus
+	}{
+		{"up to date", "sha256:1", ArchiveUpToDate},
+		{"stale", "sha256:2", ArchiveStale},
+		{"remote error", "", ArchiveError},
+	}
+	for _, tt := range tests {
+		if got := CheckArchive(metaPath, meta, tt.remote); got != tt.want {
+			t.Errorf("%s: CheckArchive() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if err := os.WriteFile(tarFilePath, []byte("da"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := CheckArchive(metaPath, meta, "sha256:1"); got != ArchiveIncomplete {
+		t.Errorf("CheckArchive() of a truncated tar = %s, want %s", got, ArchiveIncomplete)
+	}
+
+	if err := os.Remove(tarFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if got := CheckArchive(metaPath, meta, "sha256:1"); got != ArchiveMissing {
+		t.Errorf("CheckArchive() of a missing tar = %s, want %s", got, ArchiveMissing)
+	}
+}
+
+func TestPullRebuildsTruncatedArchive(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	populateCache(t, cacheDir, "nginx:1.25")
+
+	opts := Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: cacheDir, Offline: true}
+	first, err := Pull(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Pull(context.Background(), opts)
+	if err != nil || !second.Skipped {
+		t.Fatalf("second Pull() skipped = %v, err = %v, want skipped", second.Skipped, err)
+	}
+
+	// 模拟构建被中断后留下的不完整 tar
+	if err := os.Truncate(first.Output, first.Size/2); err != nil {
+		t.Fatal(err)
+	}
+	third, err := Pull(context.Background(), opts)
+	if err != nil || third.Skipped {
+		t.Fatalf("Pull() of a truncated tar skipped = %v, err = %v, want rebuilt", third.Skipped, err)
+	}
+	if fi, err := os.Stat(third.Output); err != nil || fi.Size() != third.Size || third.Size != first.Size {
+		t.Errorf("rebuilt tar = %v, %v, want size %d", fi, err, first.Size)
+	}
+
+	// 临时文件已经改名或删除
+	entries, err := os.ReadDir(filepath.Dir(first.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != filepath.Base(first.Output) && e.Name() != filepath.Base(archiveMetaPath(first.Output)) {
+			t.Errorf("unexpected file left in output: %s", e.Name())
+		}
+	}
+}
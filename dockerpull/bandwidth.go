@@ -0,0 +1,96 @@
+package dockerpull
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthLimiter 令牌桶限速, 同一个 limiter 可以在多个并发下载, 多次 Pull 之间共享
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒的字节数
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter 创建每秒 bytesPerSec 字节的限速器, bytesPerSec <= 0 时返回 nil, 表示不限速
+func NewBandwidthLimiter(bytesPerSec int64) *BandwidthLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSec)
+	return &BandwidthLimiter{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// WaitN 取出 n 个字节的令牌, 令牌不够时等待; 令牌可以透支, 后来的调用者按顺序排队
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedReader 读取后按所有 limiter 等待
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*BandwidthLimiter
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	n, err := l.r.Read(b)
+	for _, limiter := range l.limiters {
+		if werr := limiter.WaitN(l.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// ParseByteRate 解析 5M, 500K, 1.5M/s 这样的速率 (字节/秒), 格式同 ParseByteSize, 可以带 /s 后缀
+func ParseByteRate(s string) (int64, error) {
+	v := strings.TrimSpace(s)
+	if len(v) > 2 && strings.EqualFold(v[len(v)-2:], "/s") {
+		v = v[:len(v)-2]
+	}
+	n, err := ParseByteSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, expected a size like 500K, 5M or 1G", s)
+	}
+	return n, nil
+}
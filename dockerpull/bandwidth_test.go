@@ -0,0 +1,82 @@
+package dockerpull
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+		ok    bool
+	}{
+		{input: "1024", want: 1024, ok: true},
+		{input: "500K", want: 500 << 10, ok: true},
+		{input: "5M", want: 5 << 20, ok: true},
+		{input: "5mb", want: 5 << 20, ok: true},
+		{input: "1.5M/s", want: 3 << 19, ok: true},
+		{input: "2MiB", want: 2 << 20, ok: true},
+		{input: "1G", want: 1 << 30, ok: true},
+		{input: "fast", ok: false},
+		{input: "-1M", ok: false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseByteRate(tt.input)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseByteRate(%q) error = %v, want ok %v", tt.input, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("ParseByteRate(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+// 两个并发的读取共享一个 limiter, 总速率不超过限制
+func TestBandwidthLimiterShared(t *testing.T) {
+	const rate = 200 << 10
+	limiter := NewBandwidthLimiter(rate)
+
+	// burst 为 200K, 两个读取共 300K, 超出的 100K 需要等待 0.5 秒
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := &limitedReader{
+				ctx:      context.Background(),
+				r:        bytes.NewReader(make([]byte, 150<<10)),
+				limiters: []*BandwidthLimiter{limiter, nil},
+			}
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("elapsed = %s, want about 500ms", elapsed)
+	}
+}
+
+func TestBandwidthLimiterCancel(t *testing.T) {
+	limiter := NewBandwidthLimiter(1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := limiter.WaitN(ctx, 1<<20); err == nil {
+		t.Error("WaitN() error = nil, want context canceled")
+	}
+	if NewBandwidthLimiter(0) != nil {
+		t.Error("NewBandwidthLimiter(0) should disable limiting")
+	}
+}
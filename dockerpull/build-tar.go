@@ -0,0 +1,303 @@
+package dockerpull
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/containers/image/v5/types"
+)
+
+type TarInfo struct {
+	Ref          types.ImageReference
+	ConfigDigest string
+	LayersDigest []string
+	ImageInfo    DockerImageV2
+
+	// 远程 manifest (或 manifest list) 的 digest, 写入 tar 旁边的元数据文件
+	ManifestDigest string
+	// 平台的 manifest 的 digest
+	PlatformDigest string
+	// 是否已经合并为单个 layer
+	Squashed bool
+	// 平台, 如 linux/amd64
+	Platform string
+	// 原始 layer 的信息, 写入元数据文件
+	Layers []LayerReport
+	// squash 时合并前的 config digest 和合并后的 layer
+	SourceConfigDigest string
+	ArchiveLayers      []LayerReport
+
+	Arch string
+
+	// CacheDir layer 和 config 所在的 cache 目录, 为空时使用当前目录下的 cache
+	CacheDir string
+
+	folderPath string
+
+	// packTar 之后的输出路径和元数据
+	output string
+	meta   ArchiveMeta
+
+	log Logger
+}
+
+func (t *TarInfo) logger() Logger {
+	return loggerOrNop(t.log)
+}
+
+func (t *TarInfo) cacheDir() string {
+	if t.CacheDir == "" {
+		return "cache"
+	}
+	return t.CacheDir
+}
+
+// BuildTar 把 cache 中的 config 和 layer 组装为可以 docker load 的 tar
+func (t *TarInfo) BuildTar() error {
+
+	err := t.mkdirTmp()
+	if err != nil {
+		return fmt.Errorf("failed to create tmp directory: %w", err)
+	}
+
+	defer t.delTmp()
+
+	// 根目录生成 xx.json
+	err = t.buildConfigjson()
+	if err != nil {
+		return fmt.Errorf("failed to build config.json: %w", err)
+	}
+
+	err = t.buildLayers()
+	if err != nil {
+		return fmt.Errorf("failed to build layers: %w", err)
+	}
+
+	err = t.buildRepositoriesjson()
+	if err != nil {
+		return fmt.Errorf("failed to build repositories.json: %w", err)
+	}
+
+	// 根目录生成 manifest.json
+	err = t.buildManifestjson()
+	if err != nil {
+		return fmt.Errorf("failed to build manifest.json: %w", err)
+	}
+
+	err = t.packTar()
+	if err != nil {
+		return fmt.Errorf("failed to pack tar: %w", err)
+	}
+
+	return nil
+}
+
+func (t *TarInfo) packTar() error {
+
+	tarFilePath := t.buildTarName()
+
+	// 先删除旧的元数据, 构建中断时不会把不完整的 tar 当作已是最新
+	if err := os.Remove(archiveMetaPath(tarFilePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove metadata file: %w", err)
+	}
+
+	// 先写入同目录的临时文件再改名, 中断时不会留下不完整的 tar; 打包按扩展名判断格式, 临时文件也以 .tar 结尾
+	tmpPath := filepath.Join(filepath.Dir(tarFilePath), fmt.Sprintf(".%s.%d.tmp.tar", filepath.Base(tarFilePath), os.Getpid()))
+	defer os.Remove(tmpPath)
+
+	err := CreateTar(t.logger(), t.folderPath, tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create tar file: %v", err)
+	}
+
+	sum, err := FileSHA256(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash tar file: %v", err)
+	}
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	// windows 上目标文件存在时不能改名
+	if err := os.Remove(tarFilePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove tar: %w", err)
+	}
+	if err := os.Rename(tmpPath, tarFilePath); err != nil {
+		return fmt.Errorf("failed to move tar into place: %w", err)
+	}
+
+	t.output = tarFilePath
+	t.meta = ArchiveMeta{
+		Reference:          t.Ref.DockerReference().String(),
+		Arch:               t.Arch,
+		Platform:           t.Platform,
+		Squash:             t.Squashed,
+		ManifestDigest:     t.ManifestDigest,
+		PlatformDigest:     t.PlatformDigest,
+		ConfigDigest:       t.ConfigDigest,
+		Layers:             t.Layers,
+		SourceConfigDigest: t.SourceConfigDigest,
+		ArchiveLayers:      t.ArchiveLayers,
+		Archive:            filepath.Base(tarFilePath),
+		Sha256:             sum,
+		Size:               fi.Size(),
+		Created:            time.Now(),
+	}
+
+	err = writeArchiveMeta(tarFilePath, t.meta)
+	if err != nil {
+		return err
+	}
+
+	t.logger().Infof("%s", color.HiMagentaString("Successfully created tar:  %s", tarFilePath))
+	return nil
+}
+
+func (t *TarInfo) buildTarName() string {
+	name := fmt.Sprintf("%s_%s_%s_%s.tar", t.ImageInfo.Name, t.ImageInfo.Tag, t.Arch, t.ConfigDigest[:32])
+	return filepath.Join("output", t.ImageInfo.Namespace, t.ImageInfo.Repository, name)
+}
+
+func (t *TarInfo) mkdirTmp() error {
+	// 在 cache 目录下创建 tmp 文件夹, 每次构建使用不同的目录, 多个进程同时构建同一个镜像不会冲突
+	tmpRoot := filepath.Join(t.cacheDir(), "tmp")
+	err := os.MkdirAll(tmpRoot, 0755)
+	if err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	tmpPath, err := os.MkdirTemp(tmpRoot, t.ConfigDigest[:12]+"-")
+	if err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	t.folderPath = tmpPath
+
+	return nil
+}
+
+func (t *TarInfo) delTmp() {
+	err := os.RemoveAll(t.folderPath)
+	if err != nil {
+		t.logger().Warnf("failed to delete tmp directory: %v", err)
+	}
+}
+
+func (t *TarInfo) buildLayers() error {
+	for _, layerDigest := range t.LayersDigest {
+		oriLayerTar := layerPath(t.cacheDir(), layerDigest)
+		destLayerDir := filepath.Join(t.folderPath, layerDigest)
+		err := os.MkdirAll(destLayerDir, 0755)
+		if err != nil {
+			return fmt.Errorf("创建目录失败: %v", err)
+		}
+		destLayerTar := filepath.Join(destLayerDir, "layer.tar")
+
+		// copy file
+		err = CopyFile(t.logger(), oriLayerTar, destLayerTar)
+		if err != nil {
+			return err
+		}
+
+	}
+	return nil
+}
+
+// 根目录生成 xx.json
+func (t *TarInfo) buildConfigjson() error {
+
+	oriConfigJson := configPath(t.cacheDir(), t.ConfigDigest)
+	destConfigJson := filepath.Join(t.folderPath, t.ConfigDigest+".json")
+
+	// copy file
+	return CopyFile(t.logger(), oriConfigJson, destConfigJson)
+}
+
+type Schema2Manifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// 根目录生成 manifest.json
+func (t *TarInfo) buildManifestjson() error {
+
+	filePath := filepath.Join(t.folderPath, "manifest.json")
+
+	// 检查文件是否已存在
+	if FileExists(filePath) {
+		t.logger().Infof("file already exists, skipping: %s", filePath)
+		return nil
+	}
+
+	// 创建目标文件
+	targetFile, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create blob file: %v", err)
+	}
+	defer targetFile.Close()
+
+	return WriteJsonFile(targetFile, t.archiveManifest())
+}
+
+// archiveManifest docker-archive 根目录的 manifest.json
+func (t *TarInfo) archiveManifest() []Schema2Manifest {
+	mainfest := Schema2Manifest{
+		Config:   t.ConfigDigest + ".json",
+		RepoTags: []string{fmt.Sprintf("%s:%s", t.ImageInfo.Path, t.ImageInfo.Tag)},
+		Layers: func() []string {
+			var layers []string
+			for _, layerDigest := range t.LayersDigest {
+				layers = append(layers, layerDigest+"/layer.tar")
+			}
+			return layers
+		}(),
+	}
+
+	listData := make([]Schema2Manifest, 0)
+	listData = append(listData, mainfest)
+	return listData
+}
+
+func (t *TarInfo) buildRepositoriesjson() error {
+	// 创建  文件
+	repoFilePath := filepath.Join(t.folderPath, "repositories")
+
+	// 检查文件是否已存在
+	if FileExists(repoFilePath) {
+		t.logger().Infof("Blob already exists, skipping: %s", repoFilePath)
+		return nil
+	}
+
+	// 创建目标文件
+	repoFile, err := os.Create(repoFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to create blob file: %v", err)
+	}
+	defer repoFile.Close()
+
+	// {
+	//	"golang": {
+	//		"1.24.2-alpine3.20": "d2c830c9c895b70b315e96d9b40aa6c5135ff03f44d8a3a447488c1e1661c062"
+	//	}
+	//}
+
+	return WriteJsonFile(repoFile, t.repositories())
+}
+
+// repositories docker-archive 根目录的 repositories
+func (t *TarInfo) repositories() map[string]map[string]string {
+	repoName := t.ImageInfo.Path
+	tag := t.ImageInfo.Tag
+
+	data := make(map[string]map[string]string)
+	data[repoName] = map[string]string{
+		tag: SlicesLast(t.LayersDigest),
+	}
+	return data
+}
@@ -0,0 +1,291 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AllPlatforms ExportBundle 导出 manifest list 中所有已经在 cache 中的平台
+const AllPlatforms = "all"
+
+// BundleStats 导出或导入的统计
+type BundleStats struct {
+	// Files 导出或导入的文件数
+	Files int `json:"files"`
+	// Skipped 导入时 cache 中已经存在的文件数
+	Skipped int   `json:"skipped"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// ExportBundle 把镜像需要的 tag, manifest, config 和 layer 从 cache 打包为 tar, 目录结构和 cache 相同
+//
+// arch 为 AllPlatforms 时导出 manifest list 中所有已经在 cache 中的平台; 需要的文件不在 cache 中时返回 ErrNotInCache
+func ExportBundle(cacheDir string, images []string, arch string, w io.Writer) (BundleStats, error) {
+	var stats BundleStats
+
+	var files []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			files = append(files, p)
+		}
+	}
+
+	for _, image := range images {
+		imageFiles, err := bundleFiles(cacheDir, image, arch)
+		if err != nil {
+			return stats, &Error{Op: "export bundle", Ref: image, Err: err}
+		}
+		for _, p := range imageFiles {
+			add(p)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	for _, p := range files {
+		n, err := addBundleFile(tw, cacheDir, p)
+		if err != nil {
+			return stats, err
+		}
+		stats.Files++
+		stats.Bytes += n
+	}
+	return stats, tw.Close()
+}
+
+// bundleFiles 一个镜像需要的文件, 为 cache 目录下的相对路径
+func bundleFiles(cacheDir, image, arch string) ([]string, error) {
+	ref, _, err := ParseImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+	src := newCacheImageSource(ref, cacheDir)
+	ctx := context.Background()
+
+	var files []string
+	rel := func(p string) {
+		r, _ := filepath.Rel(cacheDir, p)
+		files = append(files, r)
+	}
+
+	if p := tagPath(cacheDir, ref.DockerReference()); p != "" {
+		rel(p)
+	}
+
+	raw, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	rel(manifestPath(cacheDir, digest.FromBytes(raw)))
+
+	// manifest list 中选择平台
+	manifests := [][]byte{raw}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		var index ocispec.Index
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return nil, err
+		}
+
+		var descs []ocispec.Descriptor
+		if arch == AllPlatforms {
+			for _, desc := range index.Manifests {
+				if FileExists(manifestPath(cacheDir, desc.Digest)) {
+					descs = append(descs, desc)
+				}
+			}
+		} else {
+			desc, ok := SelectManifest(index, arch)
+			if !ok {
+				return nil, fmt.Errorf("%w: linux/%s", ErrPlatformNotFound, arch)
+			}
+			descs = append(descs, desc)
+		}
+		if len(descs) == 0 {
+			return nil, fmt.Errorf("%w: no platform of the manifest list", ErrNotInCache)
+		}
+
+		manifests = nil
+		for _, desc := range descs {
+			instance, _, err := src.GetManifest(ctx, &desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			rel(manifestPath(cacheDir, desc.Digest))
+			manifests = append(manifests, instance)
+		}
+	}
+
+	for _, raw := range manifests {
+		man, err := manifest.FromBlob(raw, manifest.GuessMIMEType(raw))
+		if err != nil {
+			return nil, err
+		}
+		configInfo := man.ConfigInfo()
+		rel(configPath(cacheDir, configInfo.Digest.Encoded()))
+		for _, layer := range man.LayerInfos() {
+			rel(layerPath(cacheDir, layer.Digest.Encoded()))
+		}
+	}
+
+	for _, f := range files {
+		if !FileExists(filepath.Join(cacheDir, f)) {
+			return nil, fmt.Errorf("%w: %s", ErrNotInCache, filepath.ToSlash(f))
+		}
+	}
+	return files, nil
+}
+
+func addBundleFile(tw *tar.Writer, cacheDir, rel string) (int64, error) {
+	f, err := os.Open(filepath.Join(cacheDir, rel))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     filepath.ToSlash(rel),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(tw, f)
+}
+
+// ImportBundle 校验 bundle 中每个文件的 digest 后合并到 cache, 已存在的文件跳过
+//
+// 校验失败的文件不会写入 cache, 其他文件继续导入, 最后返回所有错误
+func ImportBundle(cacheDir string, r io.Reader) (BundleStats, error) {
+	var stats BundleStats
+	var errs []error
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, errors.Join(append(errs, err)...)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		skipped, err := importBundleFile(cacheDir, hdr.Name, tr)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", hdr.Name, err))
+		case skipped:
+			stats.Skipped++
+		default:
+			stats.Files++
+			stats.Bytes += hdr.Size
+		}
+	}
+	return stats, errors.Join(errs...)
+}
+
+// importBundleFile 导入一个文件, 返回是否因为已经存在而跳过
+func importBundleFile(cacheDir, name string, r io.Reader) (bool, error) {
+	name = path.Clean(name)
+	parts := strings.Split(name, "/")
+	if path.IsAbs(name) || parts[0] == ".." {
+		return false, fmt.Errorf("invalid path")
+	}
+	dst := filepath.Join(cacheDir, filepath.FromSlash(name))
+
+	switch parts[0] {
+	case "refs":
+		// refs/<domain>/<path>/_tags/<tag>, 内容是 manifest 的 digest, 用 bundle 中的覆盖本地的
+		if len(parts) < 5 || parts[len(parts)-2] != "_tags" {
+			return false, fmt.Errorf("invalid path")
+		}
+		data, err := io.ReadAll(io.LimitReader(r, 1024))
+		if err != nil {
+			return false, err
+		}
+		if _, err := digest.Parse(strings.TrimSpace(string(data))); err != nil {
+			return false, fmt.Errorf("%w: %v", ErrIntegrity, err)
+		}
+		return false, writeFileAtomic(dst, data)
+
+	case "manifests", "config", "layers":
+		// <kind>/<hex>/<file>, 目录名就是内容的 sha256
+		wantFile := map[string]string{"manifests": "manifest.json", "config": "config.json", "layers": "layer.tar"}[parts[0]]
+		if len(parts) != 3 || parts[2] != wantFile {
+			return false, fmt.Errorf("invalid path")
+		}
+		d := digest.NewDigestFromEncoded(digest.SHA256, parts[1])
+		if err := d.Validate(); err != nil {
+			return false, err
+		}
+		return importBlob(dst, d, r)
+
+	default:
+		return false, fmt.Errorf("invalid path")
+	}
+}
+
+// importBlob 和下载一样: 持有锁时写临时文件, 校验 digest 之后再改名
+func importBlob(dst string, d digest.Digest, r io.Reader) (bool, error) {
+	if FileExists(dst) {
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return false, err
+	}
+
+	lock, err := acquireLock(dst+".lock", nil)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	if FileExists(dst) {
+		return true, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	verifier := d.Verifier()
+	if _, err := io.Copy(io.MultiWriter(tmp, verifier), r); err != nil {
+		return false, err
+	}
+	if !verifier.Verified() {
+		return false, fmt.Errorf("%w: digest mismatch", ErrIntegrity)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return false, err
+	}
+	return false, nil
+}
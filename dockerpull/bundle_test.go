@@ -0,0 +1,80 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	populateCache(t, src, "nginx:1.25")
+
+	var bundle bytes.Buffer
+	stats, err := ExportBundle(src, []string{"nginx:1.25"}, "arm64", &bundle)
+	if err != nil {
+		t.Fatalf("ExportBundle() error = %v", err)
+	}
+	// tag, manifest list, manifest, config, layer
+	if stats.Files != 5 {
+		t.Errorf("exported %d files, want 5", stats.Files)
+	}
+
+	dst := t.TempDir()
+	if _, err := ImportBundle(dst, bytes.NewReader(bundle.Bytes())); err != nil {
+		t.Fatalf("ImportBundle() error = %v", err)
+	}
+	stats, err = ImportBundle(dst, bytes.NewReader(bundle.Bytes()))
+	if err != nil || stats.Skipped != 4 {
+		t.Errorf("second ImportBundle() = %+v, %v, want the 4 blobs skipped", stats, err)
+	}
+
+	// 导入之后可以离线构建
+	t.Chdir(t.TempDir())
+	if _, err := Pull(context.Background(), Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: dst, Offline: true}); err != nil {
+		t.Fatalf("offline Pull() after import error = %v", err)
+	}
+}
+
+func TestExportBundleMissing(t *testing.T) {
+	src := t.TempDir()
+	populateCache(t, src, "nginx:1.25")
+
+	_, err := ExportBundle(src, []string{"nginx:1.25"}, "amd64", io.Discard)
+	if !errors.Is(err, ErrPlatformNotFound) {
+		t.Errorf("ExportBundle() error = %v, want ErrPlatformNotFound", err)
+	}
+	_, err = ExportBundle(src, []string{"redis:7"}, "arm64", io.Discard)
+	if !errors.Is(err, ErrNotInCache) {
+		t.Errorf("ExportBundle() error = %v, want ErrNotInCache", err)
+	}
+}
+
+func TestImportBundleRejectsCorrupt(t *testing.T) {
+	var bundle bytes.Buffer
+	tw := tar.NewWriter(&bundle)
+	for _, f := range []struct{ name, body string }{
+		{"layers/2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae/layer.tar", "not foo"},
+		{"../../etc/passwd", "x"},
+		{"layers/fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9/layer.tar", "bar"},
+	} {
+		tw.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.body))})
+		tw.Write([]byte(f.body))
+	}
+	tw.Close()
+
+	dst := t.TempDir()
+	stats, err := ImportBundle(dst, &bundle)
+	if !errors.Is(err, ErrIntegrity) {
+		t.Errorf("ImportBundle() error = %v, want ErrIntegrity", err)
+	}
+	if stats.Files != 1 {
+		t.Errorf("imported %d files, want only the valid one", stats.Files)
+	}
+	if FileExists(layerPath(dst, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae")) {
+		t.Error("corrupt blob was imported")
+	}
+}
@@ -0,0 +1,346 @@
+package dockerpull
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CacheDirEnv 覆盖默认 cache 目录的环境变量
+const CacheDirEnv = "DOCKER_PULL_CACHE_DIR"
+
+// DefaultCacheDir 默认的 cache 目录, 优先使用环境变量 DOCKER_PULL_CACHE_DIR,
+// 否则为用户的 cache 目录 (Linux 为 $XDG_CACHE_HOME 或 ~/.cache, Windows 为 %LocalAppData%) 下的 docker-pull
+func DefaultCacheDir() string {
+	if dir := os.Getenv(CacheDirEnv); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		// 没有 HOME 等情况, 退回到当前目录
+		return "cache"
+	}
+	return filepath.Join(dir, "docker-pull")
+}
+
+// layerPath cache 中 layer 的路径: <cacheDir>/layers/<hex>/layer.tar
+func layerPath(cacheDir, layerDigest string) string {
+	return filepath.Join(cacheDir, "layers", layerDigest, "layer.tar")
+}
+
+// configPath cache 中 config 的路径: <cacheDir>/config/<hex>/config.json
+func configPath(cacheDir, configDigest string) string {
+	return filepath.Join(cacheDir, "config", configDigest, "config.json")
+}
+
+// CacheBlob cache 中的一个 layer 或 config
+type CacheBlob struct {
+	// Kind layers 或 config
+	Kind   string `json:"kind"`
+	Digest string `json:"digest"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	// LastUsed 最后一次下载或命中 cache 的时间
+	LastUsed time.Time `json:"lastUsed"`
+	// Images 引用这个 blob 的输出 tar 的镜像
+	Images []string `json:"images,omitempty"`
+}
+
+var cacheKinds = map[string]string{
+	"layers": "layer.tar",
+	"config": "config.json",
+}
+
+// ListCache 列出 cache 目录中的所有 blob, outputDir 不为空时根据其中的 tar 元数据填充 Images
+func ListCache(cacheDir, outputDir string) ([]CacheBlob, error) {
+	var refs map[string][]string
+	if outputDir != "" {
+		var err error
+		refs, err = cacheReferences(outputDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var blobs []CacheBlob
+	for _, kind := range []string{"config", "layers"} {
+		entries, err := os.ReadDir(filepath.Join(cacheDir, kind))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			// 目录可能只剩锁文件, 或者正在下载
+			path := filepath.Join(cacheDir, kind, entry.Name(), cacheKinds[kind])
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			blobs = append(blobs, CacheBlob{
+				Kind:     kind,
+				Digest:   "sha256:" + entry.Name(),
+				Path:     path,
+				Size:     fi.Size(),
+				LastUsed: fi.ModTime(),
+				Images:   refs[entry.Name()],
+			})
+		}
+	}
+	return blobs, nil
+}
+
+// cacheReferences 读取 outputDir 下所有 tar 的元数据, 返回 blob (不带 sha256: 前缀) 到镜像的映射
+func cacheReferences(outputDir string) (map[string][]string, error) {
+	metas, err := ListArchiveMetas(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string][]string)
+	add := func(digest, image string) {
+		digest = strings.TrimPrefix(digest, "sha256:")
+		if !slices.Contains(refs[digest], image) {
+			refs[digest] = append(refs[digest], image)
+		}
+	}
+	for _, metaPath := range metas {
+		meta, err := ReadArchiveMeta(metaPath)
+		if err != nil {
+			continue
+		}
+		image := meta.Reference + " (" + meta.Arch + ")"
+		add(meta.ConfigDigest, image)
+		for _, layer := range meta.Layers {
+			add(layer.Digest, image)
+		}
+		// squash 的 tar 还引用合并前的 config 和合并后的 layer
+		if meta.SourceConfigDigest != "" {
+			add(meta.SourceConfigDigest, image)
+		}
+		for _, layer := range meta.ArchiveLayers {
+			add(layer.Digest, image)
+		}
+	}
+	return refs, nil
+}
+
+// touchBlob 命中 cache 时更新修改时间, prune 按最后使用时间清理
+func touchBlob(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// CacheUsage cache 的磁盘占用
+type CacheUsage struct {
+	Layers      int   `json:"layers"`
+	LayersSize  int64 `json:"layersSize"`
+	Configs     int   `json:"configs"`
+	ConfigsSize int64 `json:"configsSize"`
+	// TmpSize 构建 tar 时的临时文件, 正常结束后会删除
+	TmpSize int64 `json:"tmpSize"`
+}
+
+// Total 总占用
+func (u CacheUsage) Total() int64 {
+	return u.LayersSize + u.ConfigsSize + u.TmpSize
+}
+
+// DiskUsage 统计 cache 的磁盘占用
+func DiskUsage(cacheDir string) (CacheUsage, error) {
+	var usage CacheUsage
+
+	blobs, err := ListCache(cacheDir, "")
+	if err != nil {
+		return usage, err
+	}
+	for _, blob := range blobs {
+		if blob.Kind == "layers" {
+			usage.Layers++
+			usage.LayersSize += blob.Size
+		} else {
+			usage.Configs++
+			usage.ConfigsSize += blob.Size
+		}
+	}
+
+	err = filepath.WalkDir(filepath.Join(cacheDir, "tmp"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			usage.TmpSize += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// PruneOptions 清理 cache 的条件, 多个条件同时设置时满足任意一个就删除
+type PruneOptions struct {
+	// OlderThan 删除超过这个时间没有使用的 blob
+	OlderThan time.Duration
+	// MaxSize 总大小超过 MaxSize 时, 按最后使用时间从旧到新删除
+	MaxSize int64
+	// Unreferenced 删除没有被 OutputDir 中任何 tar 引用的 blob; OutputDir 不存在或者没有 tar 的元数据时返回错误, 不删除任何 blob
+	Unreferenced bool
+	OutputDir    string
+	// DryRun 只返回会删除的 blob, 不删除
+	DryRun bool
+}
+
+// checkPruneOutputDir 输出目录必须存在并且有 tar 的元数据
+func checkPruneOutputDir(dir string) error {
+	if dir == "" {
+		return errors.New("output directory is required to find referenced blobs")
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("output directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("output directory %s is not a directory", dir)
+	}
+	metas, err := ListArchiveMetas(dir)
+	if err != nil {
+		return err
+	}
+	if len(metas) == 0 {
+		return fmt.Errorf("no archive metadata (*.tar.json) found in %s, refusing to remove every blob", dir)
+	}
+	return nil
+}
+
+// PruneCache 按条件清理 cache, 返回删除的 blob; 正在被其他进程下载的 blob 不会删除
+func PruneCache(cacheDir string, opts PruneOptions) ([]CacheBlob, error) {
+	outputDir := ""
+	if opts.Unreferenced {
+		// cache 是多个输出目录共用的, 输出目录不对时所有 blob 都会被当作没有引用而删除
+		if err := checkPruneOutputDir(opts.OutputDir); err != nil {
+			return nil, err
+		}
+		outputDir = opts.OutputDir
+	}
+	blobs, err := ListCache(cacheDir, outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// 最久没有使用的在前
+	slices.SortFunc(blobs, func(a, b CacheBlob) int {
+		return a.LastUsed.Compare(b.LastUsed)
+	})
+
+	var total int64
+	for _, blob := range blobs {
+		total += blob.Size
+	}
+
+	var removed []CacheBlob
+	var kept []CacheBlob
+	now := time.Now()
+	for _, blob := range blobs {
+		if (opts.OlderThan > 0 && now.Sub(blob.LastUsed) > opts.OlderThan) ||
+			(opts.Unreferenced && len(blob.Images) == 0) {
+			removed = append(removed, blob)
+			total -= blob.Size
+		} else {
+			kept = append(kept, blob)
+		}
+	}
+	if opts.MaxSize > 0 {
+		for len(kept) > 0 && total > opts.MaxSize {
+			removed = append(removed, kept[0])
+			total -= kept[0].Size
+			kept = kept[1:]
+		}
+	}
+
+	if opts.DryRun {
+		return removed, nil
+	}
+
+	var done []CacheBlob
+	for _, blob := range removed {
+		ok, err := removeBlob(blob.Path)
+		if err != nil {
+			return done, err
+		}
+		if ok {
+			done = append(done, blob)
+		}
+	}
+	return done, nil
+}
+
+// removeBlob 持有锁时删除 blob 文件, 锁文件和目录保留, 避免和正在等待锁的进程冲突; 锁被占用时跳过
+func removeBlob(path string) (bool, error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	ok, err := tryLockFile(f)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer unlockFile(f)
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// VerifyResult 一个 blob 的校验结果
+type VerifyResult struct {
+	CacheBlob
+	// Actual 实际内容的 digest
+	Actual string `json:"actual"`
+	OK     bool   `json:"ok"`
+	// Removed 校验失败并且已经删除
+	Removed bool `json:"removed,omitempty"`
+}
+
+// VerifyCache 重新计算每个 blob 的 sha256, 和所在目录名 (digest) 比较, remove 为 true 时删除损坏的 blob
+func VerifyCache(cacheDir string, remove bool) ([]VerifyResult, error) {
+	blobs, err := ListCache(cacheDir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var results []VerifyResult
+	for _, blob := range blobs {
+		sum, err := FileSHA256(blob.Path)
+		if err != nil {
+			return results, err
+		}
+		result := VerifyResult{
+			CacheBlob: blob,
+			Actual:    "sha256:" + sum,
+			OK:        "sha256:"+sum == blob.Digest,
+		}
+		if !result.OK && remove {
+			result.Removed, err = removeBlob(blob.Path)
+			if err != nil {
+				return results, err
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,284 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestAcquireLockWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layer.tar.lock")
+
+	first, err := acquireLock(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := make(chan struct{})
+	acquired := make(chan struct{})
+	go func() {
+		second, err := acquireLock(path, func() { close(waiting) })
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		close(acquired)
+		second.Unlock()
+	}()
+
+	select {
+	case <-waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second acquireLock did not wait for the first one")
+	}
+	select {
+	case <-acquired:
+		t.Fatal("second acquireLock succeeded while the lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second acquireLock did not get the lock after unlock")
+	}
+}
+
+// fakeBlobSource 只实现 GetBlob, 记录调用次数
+type fakeBlobSource struct {
+	types.ImageSource
+	data  []byte
+	calls atomic.Int32
+}
+
+func (s *fakeBlobSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	s.calls.Add(1)
+	time.Sleep(50 * time.Millisecond)
+	return io.NopCloser(bytes.NewReader(s.data)), int64(len(s.data)), nil
+}
+
+// 两个下载同时使用同一个 cache 目录时, blob 只下载一次
+func TestDownloadBlobSharedCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	data := []byte("layer content")
+	src := &fakeBlobSource{data: data}
+	desc := manifest.Schema2Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := &Downloader{
+				src:    src,
+				ctx:    context.Background(),
+				opts:   Options{CacheDir: cacheDir},
+				log:    nopLogger{},
+				cached: make(map[string]bool),
+			}
+			if err := d.downloadBlob(desc, SaveProps{path: "layers", name: "layer.tar"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := src.calls.Load(); n != 1 {
+		t.Errorf("GetBlob called %d times, want 1", n)
+	}
+	got, err := os.ReadFile(layerPath(cacheDir, desc.Digest.Encoded()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("layer.tar = %q, want %q", got, data)
+	}
+}
+
+func TestDownloadBlobDigestMismatch(t *testing.T) {
+	cacheDir := t.TempDir()
+	src := &fakeBlobSource{data: []byte("corrupt")}
+	desc := manifest.Schema2Descriptor{Digest: digest.FromString("expected"), Size: int64(len("corrupt"))}
+
+	ref, _, err := ParseImageRef("nginx:1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Downloader{
+		ref:    ref,
+		src:    src,
+		ctx:    context.Background(),
+		opts:   Options{CacheDir: cacheDir},
+		log:    nopLogger{},
+		cached: make(map[string]bool),
+	}
+
+	err = d.downloadBlob(desc, SaveProps{path: "layers", name: "layer.tar"})
+	if Classify(err) != ClassIntegrity {
+		t.Fatalf("downloadBlob() error = %v, want an integrity error", err)
+	}
+	if FileExists(layerPath(cacheDir, desc.Digest.Encoded())) {
+		t.Error("corrupt blob should not be kept in the cache")
+	}
+}
+
+// writeCacheBlob 在 cache 中写入一个 layer, 修改时间为 lastUsed
+func writeCacheBlob(t *testing.T, cacheDir string, data string, lastUsed time.Time) string {
+	t.Helper()
+	d := digest.FromString(data)
+	path := layerPath(cacheDir, d.Encoded())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, lastUsed, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+	return d.Encoded()
+}
+
+func TestPruneCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	outputDir := t.TempDir()
+	now := time.Now()
+
+	oldest := writeCacheBlob(t, cacheDir, "oldest", now.Add(-72*time.Hour))
+	older := writeCacheBlob(t, cacheDir, "older", now.Add(-48*time.Hour))
+	recent := writeCacheBlob(t, cacheDir, "recent", now)
+
+	// recent 被输出目录中的 tar 引用
+	meta := ArchiveMeta{Reference: "docker.io/library/nginx:1.25", Arch: "amd64", Layers: []LayerReport{{Digest: "sha256:" + recent}}}
+	if err := writeArchiveMeta(filepath.Join(outputDir, "nginx.tar"), meta); err != nil {
+		t.Fatal(err)
+	}
+
+	prunedDigests := func(blobs []CacheBlob) []string {
+		var digests []string
+		for _, blob := range blobs {
+			digests = append(digests, strings.TrimPrefix(blob.Digest, "sha256:"))
+		}
+		return digests
+	}
+
+	tests := []struct {
+		name string
+		opts PruneOptions
+		want []string
+	}{
+		{"older than", PruneOptions{OlderThan: 60 * time.Hour}, []string{oldest}},
+		{"max size lru", PruneOptions{MaxSize: int64(len("recent") + len("older"))}, []string{oldest}},
+		{"max size keeps newest", PruneOptions{MaxSize: int64(len("recent"))}, []string{oldest, older}},
+		{"unreferenced", PruneOptions{Unreferenced: true, OutputDir: outputDir}, []string{oldest, older}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.DryRun = true
+			removed, err := PruneCache(cacheDir, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := prunedDigests(removed); !slices.Equal(got, tt.want) {
+				t.Errorf("PruneCache() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 输出目录为空, 不存在或者没有元数据时, 所有 blob 都会被当作没有引用, 必须拒绝
+	for _, dir := range []string{"", filepath.Join(outputDir, "missing"), t.TempDir()} {
+		if removed, err := PruneCache(cacheDir, PruneOptions{Unreferenced: true, OutputDir: dir}); err == nil {
+			t.Errorf("PruneCache(OutputDir: %q) = %v, want error", dir, removed)
+		}
+	}
+	if !FileExists(layerPath(cacheDir, oldest)) {
+		t.Fatal("blob removed with an invalid output directory")
+	}
+
+	removed, err := PruneCache(cacheDir, PruneOptions{OlderThan: 60 * time.Hour})
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("PruneCache() = %v, %v", removed, err)
+	}
+	if FileExists(layerPath(cacheDir, oldest)) {
+		t.Error("pruned blob still exists")
+	}
+}
+
+func TestPruneCacheSquashed(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	layer := populateCache(t, cacheDir, "nginx:1.25")
+	// squash 需要读取 layer 的内容
+	writeTestLayer(t, filepath.Dir(layerPath(cacheDir, layer.Encoded())), "layer.tar", []testEntry{{name: "etc/nginx.conf", typeflag: tar.TypeReg, body: "conf"}})
+
+	result, err := Pull(context.Background(), Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: cacheDir, Offline: true, Squash: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SourceConfigDigest == "" || len(result.ArchiveLayers) != 1 {
+		t.Fatalf("squashed result = %+v, want source config and squashed layer", result.ArchiveMeta)
+	}
+	squashedLayer := strings.TrimPrefix(result.ArchiveLayers[0].Digest, "sha256:")
+	if fi, err := os.Stat(layerPath(cacheDir, squashedLayer)); err != nil || fi.Size() != result.ArchiveLayers[0].Size {
+		t.Errorf("squashed layer = %v, %v, want size %d", fi, err, result.ArchiveLayers[0].Size)
+	}
+
+	// 合并前的 config 和合并后的 layer 都被 tar 引用, 不能被清理
+	removed, err := PruneCache(cacheDir, PruneOptions{Unreferenced: true, OutputDir: "output"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("PruneCache() removed %v, want nothing", removed)
+	}
+	for _, path := range []string{configPath(cacheDir, result.SourceConfigDigest), configPath(cacheDir, result.ConfigDigest), layerPath(cacheDir, squashedLayer)} {
+		if !FileExists(path) {
+			t.Errorf("%s was pruned", path)
+		}
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	good := writeCacheBlob(t, cacheDir, "good", time.Now())
+	bad := writeCacheBlob(t, cacheDir, "bad", time.Now())
+	if err := os.WriteFile(layerPath(cacheDir, bad), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyCache(cacheDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		switch strings.TrimPrefix(result.Digest, "sha256:") {
+		case good:
+			if !result.OK {
+				t.Errorf("good blob reported as corrupt")
+			}
+		case bad:
+			if result.OK || !result.Removed {
+				t.Errorf("bad blob result = %+v, want corrupt and removed", result)
+			}
+		}
+	}
+	if !FileExists(layerPath(cacheDir, good)) || FileExists(layerPath(cacheDir, bad)) {
+		t.Error("VerifyCache() should only remove the corrupt blob")
+	}
+}
@@ -0,0 +1,212 @@
+package dockerpull
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+)
+
+// ErrorClass 错误的分类, 命令行根据分类返回不同的退出码并给出提示
+type ErrorClass int
+
+const (
+	ClassUnknown ErrorClass = iota
+	// ClassNotFound 镜像, tag 或平台不存在
+	ClassNotFound
+	// ClassUnauthorized 鉴权失败或没有权限
+	ClassUnauthorized
+	// ClassRateLimited registry 限流 (HTTP 429)
+	ClassRateLimited
+	// ClassNetwork 连接失败, 超时, DNS 或代理错误
+	ClassNetwork
+	// ClassTLS 证书或 TLS 握手错误
+	ClassTLS
+	// ClassIntegrity 下载的内容和 digest 或大小不一致
+	ClassIntegrity
+	// ClassLocalIO 本地文件读写错误, 如磁盘已满, 没有权限
+	ClassLocalIO
+)
+
+// ErrIntegrity 下载的 blob 和 digest 或大小不一致
+var ErrIntegrity = errors.New("content does not match digest")
+
+var classNames = map[ErrorClass]string{
+	ClassUnknown:      "error",
+	ClassNotFound:     "not found",
+	ClassUnauthorized: "unauthorized",
+	ClassRateLimited:  "rate limited",
+	ClassNetwork:      "network error",
+	ClassTLS:          "tls error",
+	ClassIntegrity:    "integrity error",
+	ClassLocalIO:      "local i/o error",
+}
+
+func (c ErrorClass) String() string {
+	return classNames[c]
+}
+
+// ExitCode 命令行的退出码, 2 留给参数错误
+func (c ErrorClass) ExitCode() int {
+	if c == ClassUnknown {
+		return 1
+	}
+	return int(c) + 2
+}
+
+// Classify 判断错误的分类
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ClassUnknown
+	}
+
+	// 证书错误也会包装在 url.Error 里, 需要在网络错误之前判断
+	if isTLSError(err) {
+		return ClassTLS
+	}
+
+	var rlErr *RateLimitError
+	if errors.Is(err, docker.ErrTooManyRequests) || errors.As(err, &rlErr) {
+		return ClassRateLimited
+	}
+	var unauthorized docker.ErrUnauthorizedForCredentials
+	if errors.As(err, &unauthorized) {
+		return ClassUnauthorized
+	}
+	if errors.Is(err, ErrPlatformNotFound) || errors.Is(err, ErrNoMatchingTags) || errors.Is(err, ErrNotInCache) {
+		return ClassNotFound
+	}
+	if errors.Is(err, ErrIntegrity) {
+		return ClassIntegrity
+	}
+
+	var ecErr errcode.Error
+	if errors.As(err, &ecErr) {
+		switch ecErr.Code {
+		case v2.ErrorCodeDigestInvalid, v2.ErrorCodeSizeInvalid:
+			return ClassIntegrity
+		}
+		if c, ok := classifyStatus(ecErr.Code.Descriptor().HTTPStatusCode); ok {
+			return c
+		}
+	}
+	var statusErr docker.UnexpectedHTTPStatusError
+	if errors.As(err, &statusErr) {
+		if c, ok := classifyStatus(statusErr.StatusCode); ok {
+			return c
+		}
+	}
+
+	// syscall.Errno 也实现了 net.Error, 本地文件错误需要在网络错误之前判断
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) {
+		return ClassLocalIO
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return ClassNetwork
+	}
+
+	return ClassUnknown
+}
+
+func classifyStatus(code int) (ErrorClass, bool) {
+	switch code {
+	case http.StatusNotFound:
+		return ClassNotFound, true
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ClassUnauthorized, true
+	case http.StatusTooManyRequests:
+		return ClassRateLimited, true
+	}
+	return ClassUnknown, false
+}
+
+func isTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	var record tls.RecordHeaderError
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &verification) ||
+		errors.As(err, &record)
+}
+
+// Hint 根据错误分类给出处理建议, 没有建议时返回空字符串
+func Hint(err error) string {
+	host := errorHost(err)
+
+	switch Classify(err) {
+	case ClassNotFound:
+		if errors.Is(err, ErrNotInCache) {
+			return "offline mode only uses the cache, pull the image and platform once online with the same -cache-dir first"
+		}
+		if errors.Is(err, ErrPlatformNotFound) {
+			return "the image does not provide this platform, use `inspect` to list the available platforms and choose one with -arch"
+		}
+		return "check the image name and tag, use `tags` to list the tags of the repository"
+	case ClassUnauthorized:
+		return "the repository may be private or not exist; check the image name, private images need credentials (docker login)"
+	case ClassRateLimited:
+		if host == "registry-1.docker.io" || host == "docker.io" {
+			return "Docker Hub pull rate limit reached, wait a few hours, log in, or pull through a mirror"
+		}
+		return "the registry is rate limiting requests, wait a moment and try again"
+	case ClassNetwork:
+		if host == "registry-1.docker.io" || host == "auth.docker.io" || host == "docker.io" {
+			return "cannot reach Docker Hub, if it is blocked on your network try -proxy, e.g. -proxy http://127.0.0.1:7890"
+		}
+		return "check the network and the registry address, use -proxy if the registry is only reachable through a proxy"
+	case ClassTLS:
+		return "the registry certificate is not trusted, check the system CA certificates or the proxy that intercepts TLS"
+	case ClassIntegrity:
+		return "the downloaded content is corrupt, run again to download it again"
+	case ClassLocalIO:
+		return "check free disk space and write permission of the cache directory (-cache-dir) and the output directory"
+	}
+	return ""
+}
+
+// errorHost 找出出错的 registry 地址, 优先使用实际请求的 URL
+func errorHost(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u, perr := url.Parse(urlErr.URL); perr == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		name := strings.TrimPrefix(e.Ref, "//")
+		if i := strings.Index(name, "/"); i > 0 {
+			domain := name[:i]
+			if strings.ContainsAny(domain, ".:") || domain == "localhost" {
+				return domain
+			}
+		}
+		return "docker.io"
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package dockerpull
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+)
+
+func TestClassify(t *testing.T) {
+	timeout := &url.Error{
+		Op:  "Get",
+		URL: "https://registry-1.docker.io/v2/",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+		code int
+	}{
+		{"unknown", errors.New("boom"), ClassUnknown, 1},
+		{"manifest unknown", &Error{Op: "get manifest", Ref: "docker.io/library/nginx:nope", Err: fmt.Errorf("reading manifest: %w", v2.ErrorCodeManifestUnknown.WithMessage("manifest unknown"))}, ClassNotFound, 3},
+		{"platform", fmt.Errorf("%w: linux/s390x", ErrPlatformNotFound), ClassNotFound, 3},
+		{"status 404", docker.UnexpectedHTTPStatusError{StatusCode: 404}, ClassNotFound, 3},
+		{"credentials", docker.ErrUnauthorizedForCredentials{Err: errors.New("denied")}, ClassUnauthorized, 4},
+		{"denied", errcode.ErrorCodeDenied.WithMessage("requested access to the resource is denied"), ClassUnauthorized, 4},
+		{"rate limited", &Error{Op: "get manifest", Err: docker.ErrTooManyRequests}, ClassRateLimited, 5},
+		{"timeout", &Error{Op: "create image source", Ref: "docker.io/library/nginx:1.25", Err: timeout}, ClassNetwork, 6},
+		{"refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ClassNetwork, 6},
+		{"tls", &url.Error{Op: "Get", URL: "https://example.com/v2/", Err: x509.UnknownAuthorityError{}}, ClassTLS, 7},
+		{"digest mismatch", &Error{Op: "verify blob", Err: fmt.Errorf("%w: digest mismatch", ErrIntegrity)}, ClassIntegrity, 8},
+		{"disk full", &Error{Op: "copy blob", Err: &os.PathError{Op: "write", Path: "cache/layers/x", Err: syscall.ENOSPC}}, ClassLocalIO, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(tt.err)
+			if got != tt.want {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+			if got.ExitCode() != tt.code {
+				t.Errorf("ExitCode() = %d, want %d", got.ExitCode(), tt.code)
+			}
+		})
+	}
+}
+
+func TestHintSuggestsProxyForDockerHub(t *testing.T) {
+	err := &Error{Op: "create image source", Ref: "docker.io/library/nginx:1.25", Err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}}
+	if hint := Hint(err); !strings.Contains(hint, "-proxy") {
+		t.Errorf("Hint() = %q, want a -proxy suggestion", hint)
+	}
+
+	err = &Error{Op: "create image source", Ref: "myregistry.com:5000/app:v1", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}
+	if hint := Hint(err); strings.Contains(hint, "Docker Hub") {
+		t.Errorf("Hint() = %q, should not mention Docker Hub for a private registry", hint)
+	}
+}
@@ -0,0 +1,239 @@
+package dockerpull
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeImages 读取 docker-compose 文件中所有 services.*.image, 按 compose 的规则替换 ${VAR:-default} 等变量, 去重后排序返回
+//
+// 变量先从环境变量中查找, 再从 env 中查找 (同 compose, 环境变量优先); 只有 build 没有 image 的服务会被忽略
+func ComposeImages(path string, env map[string]string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var compose struct {
+		Services map[string]struct {
+			Image string `yaml:"image"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return nil, fmt.Errorf("invalid compose file %s: %w", path, err)
+	}
+
+	lookup := func(name string) (string, bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			return v, true
+		}
+		v, ok := env[name]
+		return v, ok
+	}
+
+	// map 的顺序是随机的, 按服务名称排序, 同一个镜像的不同写法总是保留相同的一个
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var images imageSet
+	for _, name := range names {
+		service := compose.Services[name]
+		if service.Image == "" {
+			continue
+		}
+		image, err := interpolate(service.Image, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		if err := images.add(image); err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	return images.sorted(), nil
+}
+
+// imageSet 去重的镜像列表, nginx 和 docker.io/library/nginx:latest 是同一个镜像
+type imageSet struct {
+	seen   map[string]bool
+	images []string
+}
+
+func (s *imageSet) add(image string) error {
+	ref, _, err := ParseImageRef(image)
+	if err != nil {
+		return fmt.Errorf("invalid image %q: %w", image, err)
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	key := ref.DockerReference().String()
+	if !s.seen[key] {
+		s.seen[key] = true
+		s.images = append(s.images, image)
+	}
+	return nil
+}
+
+func (s *imageSet) sorted() []string {
+	sort.Strings(s.images)
+	return s.images
+}
+
+// interpolate 替换 $VAR, ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?error}, ${VAR?error}, $$ 表示 $
+//
+// 带冒号的形式在变量为空时也使用默认值或报错; 没有设置的变量替换为空字符串
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch {
+		case s[i] == '$':
+			b.WriteByte('$')
+
+		case s[i] == '{':
+			end := closingBrace(s, i)
+			if end < 0 {
+				return "", fmt.Errorf("unclosed variable in %q", s)
+			}
+			v, err := expandBraced(s[i+1:end], lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i = end
+
+		case isVarChar(s[i], true):
+			j := i
+			for j < len(s) && isVarChar(s[j], j == i) {
+				j++
+			}
+			v, _ := lookup(s[i:j])
+			b.WriteString(v)
+			i = j - 1
+
+		default:
+			b.WriteByte('$')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// closingBrace s[open] 为 { 时返回对应的 } 的位置, 默认值中可以嵌套变量
+func closingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expandBraced 替换 ${...} 中的内容
+func expandBraced(expr string, lookup func(string) (string, bool)) (string, error) {
+	n := 0
+	for n < len(expr) && isVarChar(expr[n], n == 0) {
+		n++
+	}
+	name, rest := expr[:n], expr[n:]
+	if name == "" {
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+	v, ok := lookup(name)
+	if rest == "" {
+		return v, nil
+	}
+
+	// 带冒号时空值和未设置相同
+	unset := !ok
+	if strings.HasPrefix(rest, ":") {
+		unset = v == ""
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+
+	op, arg := rest[0], rest[1:]
+	switch op {
+	case '-':
+		if unset {
+			return interpolate(arg, lookup)
+		}
+		return v, nil
+	case '?':
+		if unset {
+			msg, err := interpolate(arg, lookup)
+			if err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("required variable %s is missing a value: %s", name, msg)
+		}
+		return v, nil
+	case '+':
+		if !unset {
+			return interpolate(arg, lookup)
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+}
+
+func isVarChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// LoadEnvFile 读取 compose 的 .env 文件: 每行 KEY=VALUE, # 开头为注释, 值可以用单引号或双引号括起来, 可以带 export 前缀
+func LoadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: invalid line, want KEY=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			// 不带引号时 " #" 之后是注释
+			value = strings.TrimSpace(value[:i])
+		}
+		env[key] = value
+	}
+	return env, scanner.Err()
+}
@@ -0,0 +1,134 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"TAG": "1.25", "EMPTY": "", "REGISTRY": "harbor.example.com"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "nginx:$TAG", want: "nginx:1.25"},
+		{in: "nginx:${TAG}-alpine", want: "nginx:1.25-alpine"},
+		{in: "nginx:${MISSING:-1.24}", want: "nginx:1.24"},
+		{in: "nginx:${EMPTY:-1.24}", want: "nginx:1.24"},
+		{in: "nginx:${EMPTY-1.24}", want: "nginx:"},
+		{in: "${REGISTRY:-docker.io}/app:${APP_TAG:-${TAG}}", want: "harbor.example.com/app:1.25"},
+		{in: "nginx:${TAG:+stable}", want: "nginx:stable"},
+		{in: "price$$5", want: "price$5"},
+		{in: "nginx:${MISSING:?set MISSING first}", wantErr: true},
+		{in: "nginx:${TAG", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := interpolate(tt.in, lookup)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("interpolate(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestComposeImages(t *testing.T) {
+	dir := t.TempDir()
+	composeFile := filepath.Join(dir, "docker-compose.yml")
+	err := os.WriteFile(composeFile, []byte(`
+services:
+  web:
+    image: nginx:${NGINX_TAG:-1.25}
+  proxy:
+    image: docker.io/library/nginx:1.25
+  db:
+    image: "${REGISTRY}/postgres:16"
+  app:
+    build: .
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envFile := filepath.Join(dir, ".env")
+	if err := os.WriteFile(envFile, []byte("# registry\nexport REGISTRY=harbor.example.com\nUNUSED='a b' \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := LoadEnvFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["UNUSED"] != "a b" {
+		t.Errorf("LoadEnvFile() = %v", env)
+	}
+
+	images, err := ComposeImages(composeFile, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// web 和 proxy 是同一个镜像, 保留按服务名称排序后第一个 (proxy) 的写法
+	want := []string{"docker.io/library/nginx:1.25", "harbor.example.com/postgres:16"}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("ComposeImages() = %v, want %v", images, want)
+	}
+}
+
+func TestMergeArchives(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	layer := populateCache(t, cacheDir, "nginx:1.25")
+	populateCache(t, cacheDir, "nginx:1.26")
+
+	var archives []string
+	for _, image := range []string{"nginx:1.25", "nginx:1.26"} {
+		result, err := Pull(context.Background(), Options{Image: image, Arch: "arm64", CacheDir: cacheDir, Offline: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, result.Output)
+	}
+
+	var buf bytes.Buffer
+	if err := MergeArchives(&buf, archives); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	var manifests []Schema2Manifest
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[hdr.Name]++
+		if hdr.Name == "manifest.json" {
+			json.NewDecoder(tr).Decode(&manifests)
+		}
+	}
+
+	if counts[layer.Encoded()+"/layer.tar"] != 1 || counts["manifest.json"] != 1 || counts["repositories"] != 1 {
+		t.Errorf("entries = %v, want the shared layer, manifest.json and repositories once", counts)
+	}
+	var tags []string
+	for _, m := range manifests {
+		tags = append(tags, m.RepoTags...)
+	}
+	if got := strings.Join(tags, ","); !strings.Contains(got, ":1.25") || !strings.Contains(got, ":1.26") {
+		t.Errorf("RepoTags = %s, want both tags", got)
+	}
+}
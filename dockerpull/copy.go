@@ -0,0 +1,167 @@
+package dockerpull
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// CopyOptions Copy 的参数
+type CopyOptions struct {
+	// Source 源镜像, 格式同下载, 如 nginx:1.25
+	Source string
+	// Destination 目标镜像, 如 harbor.example.com/mirror/nginx:1.25
+	Destination string
+
+	// Proxy 访问 registry 使用的代理
+	Proxy *url.URL
+	// SourceSystemContext, DestinationSystemContext 不为空时代替根据 Proxy 创建的 SystemContext, 两个 registry 可以使用不同的鉴权和 TLS 设置
+	SourceSystemContext      *types.SystemContext
+	DestinationSystemContext *types.SystemContext
+	// Force 目标的 manifest digest 和源相同时也重新检查每个 blob 并上传 manifest
+	Force bool
+
+	// Logger 日志, 为空时不输出日志
+	Logger Logger
+}
+
+// CopyResult 一次 copy 的结果
+type CopyResult struct {
+	Source         string `json:"source"`
+	Destination    string `json:"destination"`
+	ManifestDigest string `json:"manifestDigest"`
+	// Platforms manifest list 中复制的平台数, 单平台镜像为 1
+	Platforms int `json:"platforms"`
+	// UpToDate 目标已经是同一个 manifest, 没有复制
+	UpToDate bool `json:"upToDate"`
+	TransferStats
+	DurationMs int64 `json:"durationMs"`
+}
+
+// Copy 把镜像从一个 registry 复制到另一个 registry, blob 从源 registry 流式上传, 不经过本地磁盘
+//
+// manifest list 会复制所有平台; blob 和 manifest 原样上传, digest 和源相同; 目标中已经存在的 blob 跳过
+func Copy(ctx context.Context, opts CopyOptions) (CopyResult, error) {
+	startedAt := time.Now()
+	log := loggerOrNop(opts.Logger)
+	result := CopyResult{Source: opts.Source, Destination: opts.Destination}
+
+	srcSysCtx, dstSysCtx := opts.SourceSystemContext, opts.DestinationSystemContext
+	if srcSysCtx == nil {
+		srcSysCtx = NewSystemContext(opts.Proxy)
+	}
+	if dstSysCtx == nil {
+		dstSysCtx = NewSystemContext(opts.Proxy)
+	}
+
+	copyErr := func(op string, err error) error {
+		return &Error{Op: op, Ref: opts.Destination, Err: err}
+	}
+
+	srcRef, _, err := ParseImageRef(opts.Source)
+	if err != nil {
+		return result, &Error{Op: "parse image", Ref: opts.Source, Err: err}
+	}
+	dstRef, err := docker.ParseReference("//" + opts.Destination)
+	if err != nil {
+		return result, copyErr("parse destination", err)
+	}
+
+	src, err := srcRef.NewImageSource(ctx, srcSysCtx)
+	if err != nil {
+		return result, &Error{Op: "create image source", Ref: opts.Source, Err: err}
+	}
+	defer src.Close()
+
+	rawManifest, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return result, &Error{Op: "get manifest", Ref: opts.Source, Err: err}
+	}
+	d, err := manifest.Digest(rawManifest)
+	if err != nil {
+		return result, &Error{Op: "compute digest", Ref: opts.Source, Err: err}
+	}
+	result.ManifestDigest = d.String()
+
+	// 目标 tag 已经指向同一个 manifest 时不需要复制
+	if !opts.Force {
+		if existing, err := docker.GetDigest(ctx, dstSysCtx, dstRef); err == nil && existing == d {
+			log.Infof("%s is up to date (%s)", opts.Destination, d)
+			result.UpToDate = true
+			result.DurationMs = time.Since(startedAt).Milliseconds()
+			return result, nil
+		}
+	}
+
+	dest, err := dstRef.NewImageDestination(ctx, dstSysCtx)
+	if err != nil {
+		return result, copyErr("create image destination", err)
+	}
+	defer dest.Close()
+
+	p := &pusher{ctx: ctx, src: src, dest: dest, log: log, stats: &result.TransferStats}
+
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(rawManifest, mimeType)
+		if err != nil {
+			return result, &Error{Op: "parse manifest list", Ref: opts.Source, Err: err}
+		}
+		// 先上传每个平台的 manifest, 最后上传 manifest list
+		for _, instance := range list.Instances() {
+			raw, instanceType, err := src.GetManifest(ctx, &instance)
+			if err != nil {
+				return result, &Error{Op: "get manifest", Ref: opts.Source, Digest: instance.String(), Err: err}
+			}
+			if err := p.copyImage(raw, instanceType, &instance); err != nil {
+				return result, &Error{Op: "copy image", Ref: opts.Destination, Digest: instance.String(), Err: err}
+			}
+			result.Platforms++
+		}
+	} else {
+		if err := p.copyImage(rawManifest, mimeType, nil); err != nil {
+			return result, copyErr("copy image", err)
+		}
+		result.Platforms = 1
+	}
+
+	if err := dest.PutManifest(ctx, rawManifest, nil); err != nil {
+		return result, copyErr("put manifest", err)
+	}
+	if err := dest.Commit(ctx, image.UnparsedInstance(src, nil)); err != nil {
+		return result, copyErr("commit", err)
+	}
+
+	result.DurationMs = time.Since(startedAt).Milliseconds()
+	log.Infof("Copied %s to %s (%s)", opts.Source, opts.Destination, d)
+	return result, nil
+}
+
+// copyImage 复制一个平台的 config 和 layer, 然后上传 manifest; instanceDigest 为空时 manifest 由调用者上传
+func (p *pusher) copyImage(raw []byte, mimeType string, instanceDigest *digest.Digest) error {
+	man, err := manifest.FromBlob(raw, mimeType)
+	if err != nil {
+		return err
+	}
+
+	if config := man.ConfigInfo(); config.Digest != "" {
+		if err := p.copyBlob(config, true); err != nil {
+			return err
+		}
+	}
+	for _, layer := range man.LayerInfos() {
+		if err := p.copyBlob(layer.BlobInfo, false); err != nil {
+			return &Error{Op: "copy layer", Digest: layer.Digest.String(), Err: err}
+		}
+	}
+
+	if instanceDigest == nil {
+		return nil
+	}
+	return p.dest.PutManifest(p.ctx, raw, instanceDigest)
+}
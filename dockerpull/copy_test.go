@@ -0,0 +1,154 @@
+package dockerpull
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// seedRegistry 在 registry 中放入一个只有一个平台的 manifest list, 返回 manifest list
+func seedRegistry(t *testing.T, reg *memoryRegistry) []byte {
+	t.Helper()
+
+	config := []byte(`{"architecture":"arm64","os":"linux"}`)
+	layer := []byte("compressed layer")
+	man, _ := json.Marshal(manifest.Schema2{
+		SchemaVersion:     2,
+		MediaType:         manifest.DockerV2Schema2MediaType,
+		ConfigDescriptor:  manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2ConfigMediaType, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		LayersDescriptors: []manifest.Schema2Descriptor{{MediaType: manifest.DockerV2Schema2LayerMediaType, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	list, _ := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{{
+			MediaType: manifest.DockerV2Schema2MediaType,
+			Digest:    digest.FromBytes(man),
+			Size:      int64(len(man)),
+			Platform:  &ocispec.Platform{OS: "linux", Architecture: "arm64"},
+		}},
+	})
+
+	reg.blobs[digest.FromBytes(config)] = config
+	reg.blobs[digest.FromBytes(layer)] = layer
+	reg.manifests[digest.FromBytes(man).String()] = man
+	reg.manifests["1.25"] = list
+	return list
+}
+
+func TestCopy(t *testing.T) {
+	srcReg, srcHost := newMemoryRegistry(t)
+	dstReg, dstHost := newMemoryRegistry(t)
+	list := seedRegistry(t, srcReg)
+
+	insecure := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+	opts := CopyOptions{
+		Source:                   srcHost + "/library/nginx:1.25",
+		Destination:              dstHost + "/mirror/nginx:1.25",
+		SourceSystemContext:      insecure,
+		DestinationSystemContext: insecure,
+	}
+
+	result, err := Copy(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Platforms != 1 || result.Uploaded != 2 || result.UpToDate {
+		t.Errorf("Copy() = %+v, want 1 platform and 2 uploaded blobs", result)
+	}
+
+	// manifest list 和 digest 不变
+	if got := dstReg.manifests["1.25"]; !bytes.Equal(got, list) {
+		t.Errorf("destination manifest list = %s, want %s", got, list)
+	}
+	if result.ManifestDigest != digest.FromBytes(list).String() {
+		t.Errorf("ManifestDigest = %s, want %s", result.ManifestDigest, digest.FromBytes(list))
+	}
+	for d := range srcReg.blobs {
+		if _, ok := dstReg.blobs[d]; !ok {
+			t.Errorf("blob %s was not copied", d)
+		}
+	}
+
+	// 第二次复制时目标已经是最新的
+	result, err = Copy(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("second Copy() error = %v", err)
+	}
+	if !result.UpToDate || dstReg.puts != 2 {
+		t.Errorf("second Copy() = %+v, blob uploads = %d, want up to date", result, dstReg.puts)
+	}
+
+	// -force 时重新检查, blob 已经存在而跳过
+	opts.Force = true
+	result, err = Copy(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("forced Copy() error = %v", err)
+	}
+	if result.UpToDate || result.Uploaded != 0 || result.Skipped != 2 {
+		t.Errorf("forced Copy() = %+v, want all blobs skipped", result)
+	}
+}
+
+func TestLoadSyncConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.yaml")
+	os.WriteFile(path, []byte(`
+destination: harbor.example.com/mirror/
+images:
+  - source: nginx:1.25
+  - source: redis
+    tags: ["7.2", "7.4"]
+  - source: quay.io/prometheus/prometheus:v2.53.0
+    destination: harbor.example.com/monitoring/prometheus
+`), 0644)
+
+	cfg, err := LoadSyncConfig(path)
+	if err != nil {
+		t.Fatalf("LoadSyncConfig() error = %v", err)
+	}
+
+	pairs, err := ResolveSync(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("ResolveSync() error = %v", err)
+	}
+	want := []SyncPair{
+		{Source: "nginx:1.25", Destination: "harbor.example.com/mirror/library/nginx:1.25"},
+		{Source: "redis:7.2", Destination: "harbor.example.com/mirror/library/redis:7.2"},
+		{Source: "redis:7.4", Destination: "harbor.example.com/mirror/library/redis:7.4"},
+		{Source: "quay.io/prometheus/prometheus:v2.53.0", Destination: "harbor.example.com/monitoring/prometheus:v2.53.0"},
+	}
+	if len(pairs) != len(want) {
+		t.Fatalf("ResolveSync() = %v, want %v", pairs, want)
+	}
+	for i := range want {
+		if pairs[i] != want[i] {
+			t.Errorf("pairs[%d] = %v, want %v", i, pairs[i], want[i])
+		}
+	}
+}
+
+func TestLoadSyncConfigInvalid(t *testing.T) {
+	tests := map[string]string{
+		"no destination": "images:\n  - source: nginx\n",
+		"no source":      "destination: harbor.example.com\nimages:\n  - tags: [\"1\"]\n",
+		"unknown field":  "destination: harbor.example.com\nimages:\n  - source: nginx\n    tag: latest\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sync.yaml")
+			os.WriteFile(path, []byte(content), 0644)
+			if _, err := LoadSyncConfig(path); err == nil {
+				t.Error("LoadSyncConfig() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package dockerpull
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DockerfileImage Dockerfile 中 FROM 引用的一个镜像
+type DockerfileImage struct {
+	Image string `json:"image"`
+	// Arch FROM --platform 声明的架构, 没有声明时为请求的架构
+	Arch string `json:"arch"`
+}
+
+// DockerfileImages 解析 Dockerfile 中所有 FROM 引用的镜像, 按出现顺序去重返回
+//
+// 支持多阶段构建 (引用之前阶段名称的 FROM 和 scratch 被跳过), 第一个 FROM 之前的 ARG (可以被 buildArgs 覆盖),
+// --platform=linux/arm64 和 $TARGETPLATFORM, $BUILDPLATFORM 等自动变量 (取值为 linux/<arch>), 续行和 # escape= 指令
+func DockerfileImages(path, arch string, buildArgs map[string]string) ([]DockerfileImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	images, err := parseDockerfile(f, arch, buildArgs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return images, nil
+}
+
+func parseDockerfile(r io.Reader, arch string, buildArgs map[string]string) ([]DockerfileImage, error) {
+	if arch == "" {
+		arch = "amd64"
+	}
+	platform := "linux/" + arch
+	archName, variant, _ := strings.Cut(arch, "/")
+	automatic := map[string]string{
+		"TARGETPLATFORM": platform, "TARGETOS": "linux", "TARGETARCH": archName, "TARGETVARIANT": variant,
+		"BUILDPLATFORM": platform, "BUILDOS": "linux", "BUILDARCH": archName, "BUILDVARIANT": variant,
+	}
+
+	// 第一个 FROM 之前的 ARG 是全局的, 可以在 FROM 中使用
+	args := make(map[string]string)
+	lookup := func(name string) (string, bool) {
+		if v, ok := buildArgs[name]; ok {
+			return v, true
+		}
+		if v, ok := args[name]; ok {
+			return v, true
+		}
+		v, ok := automatic[name]
+		return v, ok
+	}
+
+	instructions, err := dockerfileInstructions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make(map[string]bool)
+	seen := make(map[DockerfileImage]bool)
+	var images []DockerfileImage
+	inStage := false
+
+	for _, ins := range instructions {
+		fields := strings.Fields(ins.args)
+		switch ins.name {
+		case "ARG":
+			if inStage {
+				continue
+			}
+			for _, field := range fields {
+				// 没有默认值的 ARG 只能通过 buildArgs 设置
+				name, value, ok := strings.Cut(field, "=")
+				if !ok {
+					continue
+				}
+				v, err := interpolate(unquote(value), lookup)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", ins.line, err)
+				}
+				args[name] = v
+			}
+
+		case "FROM":
+			inStage = true
+			var fromPlatform string
+			for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+				if v, ok := strings.CutPrefix(fields[0], "--platform="); ok {
+					fromPlatform = v
+				}
+				fields = fields[1:]
+			}
+			if len(fields) != 1 && !(len(fields) == 3 && strings.EqualFold(fields[1], "AS")) {
+				return nil, fmt.Errorf("line %d: invalid FROM %q", ins.line, ins.args)
+			}
+
+			image, err := interpolate(fields[0], lookup)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", ins.line, err)
+			}
+			// 之前的阶段或者空镜像, 不需要下载; 阶段名称不区分大小写
+			isStage := stages[strings.ToLower(image)]
+			if len(fields) == 3 {
+				stages[strings.ToLower(fields[2])] = true
+			}
+			if isStage || image == "scratch" {
+				continue
+			}
+			if _, _, err := ParseImageRef(image); err != nil {
+				return nil, fmt.Errorf("line %d: invalid image %q: %w", ins.line, image, err)
+			}
+
+			imageArch := arch
+			if fromPlatform != "" {
+				p, err := interpolate(fromPlatform, lookup)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", ins.line, err)
+				}
+				platformOS, a, ok := strings.Cut(p, "/")
+				if !ok || platformOS != "linux" || a == "" {
+					return nil, fmt.Errorf("line %d: unsupported platform %q, only linux/<arch> is supported", ins.line, p)
+				}
+				imageArch = a
+			}
+
+			img := DockerfileImage{Image: image, Arch: imageArch}
+			if !seen[img] {
+				seen[img] = true
+				images = append(images, img)
+			}
+		}
+	}
+	return images, nil
+}
+
+// dockerfileInstruction 合并续行后的一条指令, name 为大写
+type dockerfileInstruction struct {
+	name string
+	args string
+	line int
+}
+
+// dockerfileInstructions 把 Dockerfile 拆分为指令: 跳过注释和空行, 合并以转义字符 (默认 \, 可以用 # escape=` 修改) 结尾的续行
+func dockerfileInstructions(r io.Reader) ([]dockerfileInstruction, error) {
+	escape := `\`
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var instructions []dockerfileInstruction
+	var current strings.Builder
+	start := 0
+	directives := true
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		// 解析指令只能在文件开头
+		if directives {
+			if d, ok := strings.CutPrefix(text, "#"); ok {
+				key, value, found := strings.Cut(strings.TrimSpace(d), "=")
+				if found && strings.EqualFold(strings.TrimSpace(key), "escape") {
+					escape = strings.TrimSpace(value)
+					if escape != `\` && escape != "`" {
+						return nil, fmt.Errorf("line %d: invalid escape character %q", line, escape)
+					}
+				}
+				continue
+			}
+			directives = false
+		}
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if current.Len() == 0 {
+			start = line
+		}
+		if s, ok := strings.CutSuffix(text, escape); ok {
+			current.WriteString(s)
+			current.WriteByte(' ')
+			continue
+		}
+		current.WriteString(text)
+		instructions = append(instructions, newDockerfileInstruction(current.String(), start))
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// 最后一行是续行
+	if current.Len() > 0 {
+		instructions = append(instructions, newDockerfileInstruction(current.String(), start))
+	}
+	return instructions, nil
+}
+
+func newDockerfileInstruction(text string, line int) dockerfileInstruction {
+	name, args := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		name, args = text[:i], text[i+1:]
+	}
+	return dockerfileInstruction{name: strings.ToUpper(name), args: strings.TrimSpace(args), line: line}
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package dockerpull
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	dockerfile := `# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.24
+ARG BASE
+ARG ALPINE=alpine:${ALPINE_VERSION:-3.20}
+
+FROM --platform=$BUILDPLATFORM golang:${GO_VERSION}-alpine AS Build
+ARG GO_VERSION=1.0
+RUN go build \
+    -o /app .
+
+FROM build AS test
+RUN go test ./...
+
+from --platform=linux/arm64 \
+     ${ALPINE} as arm
+FROM scratch AS empty
+
+FROM ${BASE:-debian:12}
+COPY --from=build /app /app
+COPY --from=BUILD /app /app2
+FROM golang:1.24-alpine
+`
+	images, err := parseDockerfile(strings.NewReader(dockerfile), "amd64", map[string]string{"GO_VERSION": "1.23"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DockerfileImage{
+		{Image: "golang:1.23-alpine", Arch: "amd64"},
+		{Image: "alpine:3.20", Arch: "arm64"},
+		{Image: "debian:12", Arch: "amd64"},
+		{Image: "golang:1.24-alpine", Arch: "amd64"},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("parseDockerfile() = %v, want %v", images, want)
+	}
+}
+
+func TestParseDockerfileEscape(t *testing.T) {
+	dockerfile := "# escape=`\nFROM `\n  mcr.microsoft.com/windows/servercore:ltsc2022 AS base\nFROM --platform=$TARGETPLATFORM base\n"
+	images, err := parseDockerfile(strings.NewReader(dockerfile), "arm64", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DockerfileImage{{Image: "mcr.microsoft.com/windows/servercore:ltsc2022", Arch: "arm64"}}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("parseDockerfile() = %v, want %v", images, want)
+	}
+}
+
+func TestParseDockerfileInvalid(t *testing.T) {
+	tests := []string{
+		"FROM\n",
+		"FROM nginx AS\n",
+		"FROM --platform=windows/amd64 nginx\n",
+		"FROM ${IMAGE:?set IMAGE}\n",
+		"FROM NGINX\n",
+	}
+	for _, dockerfile := range tests {
+		if _, err := parseDockerfile(strings.NewReader(dockerfile), "amd64", nil); err == nil {
+			t.Errorf("parseDockerfile(%q) error = nil", dockerfile)
+		}
+	}
+}
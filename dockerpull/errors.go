@@ -0,0 +1,41 @@
+package dockerpull
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPlatformNotFound manifest list 中没有请求的平台
+	ErrPlatformNotFound = errors.New("platform not found in manifest list")
+	// ErrUnsupportedManifest 不支持的 manifest 类型, 如 schema1
+	ErrUnsupportedManifest = errors.New("unsupported manifest type")
+	// ErrNoMatchingTags 没有 tag 匹配版本范围或正则
+	ErrNoMatchingTags = errors.New("no tags match")
+)
+
+// Error 带有操作和镜像信息的错误, 可以用 errors.Is / errors.As 判断原始错误
+type Error struct {
+	// Op 出错的步骤, 如 get manifest, download blob, build tar
+	Op string
+	// Ref 镜像名称
+	Ref string
+	// Digest 出错的 blob 或 manifest, 可能为空
+	Digest string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op
+	if e.Ref != "" {
+		msg += " " + e.Ref
+	}
+	if e.Digest != "" {
+		msg += " (" + e.Digest + ")"
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
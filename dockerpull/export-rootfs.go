@@ -0,0 +1,386 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/fatih/color"
+)
+
+const (
+	// whiteoutPrefix 标记删除下层的同名文件
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque 标记目录为 opaque, 下层该目录下的内容全部不可见
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ExportRootfs 按顺序合并 cache/layers 里的所有 layer, 导出扁平化的根文件系统
+//
+// dst 以 .tar 结尾时输出为单个 tar (类似 docker export), 否则输出为目录
+func (t *TarInfo) ExportRootfs(dst string) error {
+
+	var layerPaths []string
+	for _, layerDigest := range t.LayersDigest {
+		layerPaths = append(layerPaths, layerPath(t.cacheDir(), layerDigest))
+	}
+
+	var err error
+	if strings.HasSuffix(dst, ".tar") {
+		err = exportRootfsTar(layerPaths, dst)
+	} else {
+		err = exportRootfsDir(t.logger(), layerPaths, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.logger().Infof("%s", color.HiMagentaString("Successfully exported rootfs:  %s", dst))
+	return nil
+}
+
+func exportRootfsTar(layerPaths []string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %v", err)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create rootfs tar: %v", err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	err = mergeLayers(layerPaths, func(hdr *tar.Header, r io.Reader) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tw, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close rootfs tar: %v", err)
+	}
+	return nil
+}
+
+func exportRootfsDir(log Logger, layerPaths []string, root string) error {
+	// 合并到已有内容上时, 新镜像删除或没有的文件会留在 rootfs 中
+	if entries, err := os.ReadDir(root); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination directory %s is not empty, remove it or export to another directory", root)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %v", err)
+	}
+
+	// 目录的权限和时间要等里面的内容都写完再设置, 否则只读目录会导致后续写入失败
+	var dirs []*tar.Header
+
+	err := mergeLayers(layerPaths, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+		return extractEntry(log, root, hdr, r)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(root, filepath.FromSlash(dirs[i].Name))
+		applyMetadata(log, target, dirs[i])
+	}
+	return nil
+}
+
+// mergeLayers 把多个 layer 合并为一个扁平的文件流, 每个最终可见的条目回调一次 emit
+//
+// 第一遍从上往下扫描, 计算每一层中没有被上层覆盖或 whiteout 的条目;
+// 第二遍从下往上重新读取, 只输出这些条目, whiteout 文件本身不会输出
+func mergeLayers(layerPaths []string, emit func(hdr *tar.Header, r io.Reader) error) error {
+
+	keep := make([]map[string]bool, len(layerPaths))
+
+	// 上层已经定义过的路径, 下层同名条目被覆盖
+	seen := make(map[string]bool)
+	// 上层 whiteout 的路径, 路径本身和下面的内容都不可见
+	hidden := make(map[string]bool)
+	// 上层的 opaque 目录或非目录条目, 下层在它下面的内容不可见
+	opaque := make(map[string]bool)
+
+	for i := len(layerPaths) - 1; i >= 0; i-- {
+		keep[i] = make(map[string]bool)
+
+		var entries []*tar.Header
+		err := walkLayer(layerPaths[i], func(hdr *tar.Header, r io.Reader) error {
+			name := cleanEntryName(hdr.Name)
+			if name == "" {
+				return nil
+			}
+			entries = append(entries, &tar.Header{Name: name, Typeflag: hdr.Typeflag})
+
+			base := path.Base(name)
+			if strings.HasPrefix(base, whiteoutPrefix) {
+				return nil
+			}
+			if !isShadowed(name, seen, hidden, opaque) {
+				keep[i][name] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %v", layerPaths[i], err)
+		}
+
+		// 同一层的 whiteout 只作用于更下层, 所以整层扫描完再记录
+		for _, e := range entries {
+			dir, base := path.Split(e.Name)
+			dir = strings.TrimSuffix(dir, "/")
+
+			switch {
+			case base == whiteoutOpaque:
+				opaque[dir] = true
+			case strings.HasPrefix(base, whiteoutPrefix):
+				hidden[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+			default:
+				seen[e.Name] = true
+				if e.Typeflag != tar.TypeDir {
+					opaque[e.Name] = true
+				}
+			}
+		}
+	}
+
+	for i, layerPath := range layerPaths {
+		err := walkLayer(layerPath, func(hdr *tar.Header, r io.Reader) error {
+			name := cleanEntryName(hdr.Name)
+			if !keep[i][name] {
+				return nil
+			}
+
+			hdr.Name = name
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Name += "/"
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = cleanEntryName(hdr.Linkname)
+			}
+			return emit(hdr, r)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply layer %s: %v", layerPath, err)
+		}
+	}
+
+	return nil
+}
+
+// isShadowed 判断下层的 name 是否被上层覆盖
+func isShadowed(name string, seen, hidden, opaque map[string]bool) bool {
+	if seen[name] || hidden[name] {
+		return true
+	}
+	for p := path.Dir(name); p != "."; p = path.Dir(p) {
+		if hidden[p] || opaque[p] {
+			return true
+		}
+	}
+	// 根目录的 opaque
+	return opaque[""]
+}
+
+// walkLayer 解压并逐个遍历 layer 中的条目, 支持 gzip, zstd 等压缩格式, 也支持未压缩的 tar
+func walkLayer(layerPath string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, _, err := compression.AutoDecompress(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanEntryName 规范化 tar 中的路径, 去掉开头的 / 和 ./, 越过根目录的路径返回空
+func cleanEntryName(name string) string {
+	name = path.Clean("/" + name)
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// extractEntry 把单个条目写入 root 目录
+func extractEntry(log Logger, root string, hdr *tar.Header, r io.Reader) error {
+	name := cleanEntryName(hdr.Name)
+	if name == "" {
+		return nil
+	}
+
+	// 父目录中有符号链接时, 写入可能会逃逸出 root, 直接跳过
+	if parentHasSymlink(root, name) {
+		log.Warnf("Skipping %s: parent directory is a symlink", name)
+		return nil
+	}
+	// 硬链接的目标路径中有符号链接时, os.Link 会跟随它链接到 root 之外的文件
+	if hdr.Typeflag == tar.TypeLink {
+		linkname := cleanEntryName(hdr.Linkname)
+		if linkname == "" || parentHasSymlink(root, linkname) {
+			log.Warnf("Skipping hardlink %s: target %s is outside the rootfs", name, hdr.Linkname)
+			return nil
+		}
+	}
+
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// 非目录条目会替换已存在的文件
+	if hdr.Typeflag != tar.TypeDir {
+		if _, err := os.Lstat(target); err == nil {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		// 目录的元数据最后统一设置
+		return nil
+
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+
+	case tar.TypeLink:
+		linkTarget := filepath.Join(root, filepath.FromSlash(cleanEntryName(hdr.Linkname)))
+		if err := os.Link(linkTarget, target); err != nil {
+			log.Warnf("Failed to create hardlink %s: %v", name, err)
+		}
+		// 硬链接和目标共享元数据
+		return nil
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := mknod(target, hdr); err != nil {
+			log.Debugf("Skipping device %s: %v", name, err)
+			return nil
+		}
+
+	default:
+		log.Debugf("Skipping unsupported entry %s (type %c)", name, hdr.Typeflag)
+		return nil
+	}
+
+	applyMetadata(log, target, hdr)
+	return nil
+}
+
+func parentHasSymlink(root, name string) bool {
+	p := root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return false
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMetadata 尽量还原属主, 权限, 时间和 xattr; 非 root 用户运行时属主会设置失败, 忽略即可
+func applyMetadata(log Logger, target string, hdr *tar.Header) {
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		log.Debugf("Failed to chown %s: %v", target, err)
+	}
+
+	setXattrs(log, target, hdr)
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		return
+	}
+
+	if err := os.Chmod(target, hdr.FileInfo().Mode()&os.ModePerm|modeSpecial(hdr)); err != nil {
+		log.Debugf("Failed to chmod %s: %v", target, err)
+	}
+
+	mtime := hdr.ModTime
+	if mtime.IsZero() {
+		mtime = time.Unix(0, 0)
+	}
+	if err := os.Chtimes(target, mtime, mtime); err != nil {
+		log.Debugf("Failed to set times %s: %v", target, err)
+	}
+}
+
+func modeSpecial(hdr *tar.Header) os.FileMode {
+	var mode os.FileMode
+	if hdr.Mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if hdr.Mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if hdr.Mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,35 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const paxXattrPrefix = "SCHILY.xattr."
+
+func setXattrs(log Logger, target string, hdr *tar.Header) {
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		attr := strings.TrimPrefix(key, paxXattrPrefix)
+		if err := unix.Lsetxattr(target, attr, []byte(value), 0); err != nil {
+			log.Debugf("Failed to set xattr %s on %s: %v", attr, target, err)
+		}
+	}
+}
+
+func mknod(target string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	case tar.TypeFifo:
+		mode |= unix.S_IFIFO
+	}
+	return unix.Mknod(target, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package dockerpull
+
+import (
+	"archive/tar"
+	"errors"
+)
+
+// 非 linux 平台不支持 xattr, 忽略
+func setXattrs(log Logger, target string, hdr *tar.Header) {}
+
+func mknod(target string, hdr *tar.Header) error {
+	return errors.New("device files are only supported on linux")
+}
@@ -0,0 +1,207 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type testEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+// writeTestLayer 生成一个未压缩的 layer.tar
+func writeTestLayer(t *testing.T, dir, name string, entries []testEntry) string {
+	t.Helper()
+
+	p := filepath.Join(dir, name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+			Linkname: e.linkname,
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.body != "" {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMergeLayers(t *testing.T) {
+	dir := t.TempDir()
+
+	lower := writeTestLayer(t, dir, "lower.tar", []testEntry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/hostname", typeflag: tar.TypeReg, body: "lower"},
+		{name: "etc/removed", typeflag: tar.TypeReg, body: "x"},
+		{name: "var/", typeflag: tar.TypeDir},
+		{name: "var/cache/", typeflag: tar.TypeDir},
+		{name: "var/cache/old", typeflag: tar.TypeReg, body: "x"},
+		{name: "opt/", typeflag: tar.TypeDir},
+		{name: "opt/app/", typeflag: tar.TypeDir},
+		{name: "opt/app/bin", typeflag: tar.TypeReg, body: "x"},
+	})
+	upper := writeTestLayer(t, dir, "upper.tar", []testEntry{
+		{name: "./etc/hostname", typeflag: tar.TypeReg, body: "upper"},
+		{name: "etc/.wh.removed", typeflag: tar.TypeReg},
+		{name: "var/cache/.wh..wh..opq", typeflag: tar.TypeReg},
+		{name: "var/cache/new", typeflag: tar.TypeReg, body: "x"},
+		{name: "opt/app", typeflag: tar.TypeSymlink, linkname: "/usr/lib/app"},
+		{name: "etc/hosts", typeflag: tar.TypeLink, linkname: "etc/hostname"},
+	})
+
+	var names []string
+	contents := make(map[string]string)
+	err := mergeLayers([]string{lower, upper}, func(hdr *tar.Header, r io.Reader) error {
+		names = append(names, hdr.Name)
+		if hdr.Typeflag == tar.TypeReg {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			contents[hdr.Name] = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("mergeLayers() error = %v", err)
+	}
+
+	sort.Strings(names)
+	want := []string{
+		"etc/",
+		"etc/hostname",
+		"etc/hosts",
+		"opt/",
+		"opt/app",
+		"var/",
+		"var/cache/",
+		"var/cache/new",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("mergeLayers() entries = %v, want %v", names, want)
+	}
+
+	if contents["etc/hostname"] != "upper" {
+		t.Errorf("etc/hostname = %q, want %q", contents["etc/hostname"], "upper")
+	}
+}
+
+func TestCleanEntryName(t *testing.T) {
+	tests := map[string]string{
+		"./etc/passwd":     "etc/passwd",
+		"/usr/bin/":        "usr/bin",
+		"../../etc/shadow": "etc/shadow",
+		"./":               "",
+	}
+	for input, want := range tests {
+		if got := cleanEntryName(input); got != want {
+			t.Errorf("cleanEntryName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestExportRootfsDir(t *testing.T) {
+	dir := t.TempDir()
+
+	layer := writeTestLayer(t, dir, "layer.tar", []testEntry{
+		{name: "bin/", typeflag: tar.TypeDir},
+		{name: "bin/busybox", typeflag: tar.TypeReg, body: "x"},
+		{name: "bin/sh", typeflag: tar.TypeSymlink, linkname: "busybox"},
+		{name: "bin/ash", typeflag: tar.TypeLink, linkname: "bin/busybox"},
+	})
+
+	root := filepath.Join(dir, "rootfs")
+	if err := exportRootfsDir(nopLogger{}, []string{layer}, root); err != nil {
+		t.Fatalf("exportRootfsDir() error = %v", err)
+	}
+
+	if target, err := os.Readlink(filepath.Join(root, "bin", "sh")); err != nil || target != "busybox" {
+		t.Errorf("bin/sh -> %q, err = %v", target, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "bin", "ash")); err != nil || string(b) != "x" {
+		t.Errorf("bin/ash = %q, err = %v", b, err)
+	}
+}
+
+func TestExportRootfsDirHardlinkThroughSymlink(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "shadow"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// d -> 宿主机目录, 然后硬链接 d/shadow, 不能链接到 rootfs 之外的文件
+	layer := writeTestLayer(t, dir, "layer.tar", []testEntry{
+		{name: "d", typeflag: tar.TypeSymlink, linkname: outside},
+		{name: "stolen", typeflag: tar.TypeLink, linkname: "d/shadow"},
+	})
+
+	root := filepath.Join(dir, "rootfs")
+	if err := exportRootfsDir(nopLogger{}, []string{layer}, root); err != nil {
+		t.Fatalf("exportRootfsDir() error = %v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(root, "stolen")); !os.IsNotExist(err) {
+		t.Errorf("stolen was created, err = %v", err)
+	}
+}
+
+func TestExportRootfsDirNotEmpty(t *testing.T) {
+	dir := t.TempDir()
+	layer := writeTestLayer(t, dir, "layer.tar", []testEntry{
+		{name: "bin/busybox", typeflag: tar.TypeReg, body: "x"},
+	})
+
+	// 空目录可以直接使用
+	empty := filepath.Join(dir, "empty")
+	if err := os.MkdirAll(empty, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportRootfsDir(nopLogger{}, []string{layer}, empty); err != nil {
+		t.Fatalf("exportRootfsDir() of an empty directory error = %v", err)
+	}
+
+	// 再次导出到同一个目录, 之前的内容 (如旧镜像的文件) 会残留, 必须拒绝
+	stale := filepath.Join(empty, "stale")
+	if err := os.WriteFile(stale, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportRootfsDir(nopLogger{}, []string{layer}, empty); err == nil {
+		t.Error("exportRootfsDir() of a non-empty directory error = nil")
+	}
+	if !FileExists(stale) {
+		t.Error("existing content was modified")
+	}
+}
@@ -0,0 +1,42 @@
+package dockerpull
+
+import (
+	"os"
+)
+
+// fileLock 进程间的排他文件锁, 多个进程同时下载同一个 blob 时, 只有一个进程下载, 其他进程等待
+type fileLock struct {
+	f *os.File
+}
+
+// acquireLock 获取 path 的排他锁, 已被其他进程持有时先调用 onWait 再阻塞等待
+//
+// 锁文件不会删除, 删除会导致两个进程分别锁住不同的文件
+func acquireLock(path string, onWait func()) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := tryLockFile(f)
+	if err == nil && !ok {
+		if onWait != nil {
+			onWait()
+		}
+		err = lockFile(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+// Unlock 释放锁
+func (l *fileLock) Unlock() error {
+	err := unlockFile(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
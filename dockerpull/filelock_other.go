@@ -0,0 +1,21 @@
+//go:build !unix && !windows
+
+package dockerpull
+
+import (
+	"os"
+)
+
+// 不支持文件锁的平台, 不做进程间的协调
+
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// InspectResult inspect 子命令的输出, 只包含 manifest 和 config, 不下载任何 layer
+type InspectResult struct {
+	Reference      string            `json:"reference"`
+	ManifestDigest digest.Digest     `json:"manifestDigest"`
+	MediaType      string            `json:"mediaType"`
+	ManifestList   *ocispec.Index    `json:"manifestList,omitempty"`
+	Platform       *ocispec.Platform `json:"platform,omitempty"`
+	Manifest       ocispec.Manifest  `json:"manifest"`
+	Config         ocispec.Image     `json:"config"`
+}
+
+// Inspect 获取镜像的 manifest list, 选中平台的 manifest 和 config
+func Inspect(ctx context.Context, image, arch string, sysCtx *types.SystemContext) (*InspectResult, error) {
+	ref, _, err := ParseImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := ref.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image source: %v", err)
+	}
+	defer src.Close()
+
+	return inspectSource(ctx, src, arch)
+}
+
+// inspectSource 从 src 读取 manifest 和 config
+func inspectSource(ctx context.Context, src types.ImageSource, arch string) (*InspectResult, error) {
+	rawManifest, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %v", err)
+	}
+
+	manifestDigest, err := manifest.Digest(rawManifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute digest: %v", err)
+	}
+
+	result := &InspectResult{
+		Reference:      src.Reference().DockerReference().String(),
+		ManifestDigest: manifestDigest,
+		MediaType:      manifest.GuessMIMEType(rawManifest),
+	}
+
+	switch result.MediaType {
+	case manifest.DockerV2ListMediaType, ocispec.MediaTypeImageIndex:
+		// docker manifest list 和 oci index 结构相同, 统一按 oci index 解析, 保留 annotations
+		var index ocispec.Index
+		if err := json.Unmarshal(rawManifest, &index); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal manifest list: %v", err)
+		}
+		result.ManifestList = &index
+
+		desc, ok := SelectManifest(index, arch)
+		if !ok {
+			return nil, fmt.Errorf("%w: linux/%s", ErrPlatformNotFound, arch)
+		}
+		result.Platform = desc.Platform
+
+		rawManifest, _, err = src.GetManifest(ctx, &desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manifest %s: %v", desc.Digest, err)
+		}
+
+	case manifest.DockerV2Schema2MediaType, ocispec.MediaTypeImageManifest:
+
+	default:
+		return nil, fmt.Errorf("unsupported manifest type: %s", result.MediaType)
+	}
+
+	if err := json.Unmarshal(rawManifest, &result.Manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %v", err)
+	}
+
+	// config 也是 blob, 体积很小
+	configReader, _, err := src.GetBlob(ctx, types.BlobInfo{
+		Digest: result.Manifest.Config.Digest,
+		Size:   result.Manifest.Config.Size,
+	}, none.NoCache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %v", err)
+	}
+	defer configReader.Close()
+
+	if err := json.NewDecoder(configReader).Decode(&result.Config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+
+	return result, nil
+}
+
+// SelectManifest 从 manifest list 中选出 linux/arch 的 manifest, arch 可以带 variant, 如 arm/v7
+func SelectManifest(index ocispec.Index, arch string) (ocispec.Descriptor, bool) {
+	arch, variant, _ := strings.Cut(arch, "/")
+
+	for _, m := range index.Manifests {
+		if m.Platform == nil || m.Platform.OS != "linux" || m.Platform.Architecture != arch {
+			continue
+		}
+		if variant != "" && m.Platform.Variant != variant {
+			continue
+		}
+		return m, true
+	}
+	return ocispec.Descriptor{}, false
+}
+
+// PlatformString 返回 os/arch/variant 格式的平台
+func PlatformString(p *ocispec.Platform) string {
+	if p == nil {
+		return "unknown"
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
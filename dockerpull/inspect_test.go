@@ -0,0 +1,77 @@
+package dockerpull
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSelectManifest(t *testing.T) {
+	index := ocispec.Index{Manifests: []ocispec.Descriptor{
+		{Digest: "sha256:amd64", Platform: &ocispec.Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:windows", Platform: &ocispec.Platform{OS: "windows", Architecture: "arm64"}},
+		{Digest: "sha256:arm64", Platform: &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{Digest: "sha256:armv6", Platform: &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{Digest: "sha256:armv7", Platform: &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "sha256:attestation"},
+	}}
+
+	tests := []struct {
+		arch string
+		want string
+		ok   bool
+	}{
+		{"amd64", "sha256:amd64", true},
+		// 只选 linux, 跳过 windows 的 arm64
+		{"arm64", "sha256:arm64", true},
+		{"arm64/v8", "sha256:arm64", true},
+		// 不带 variant 时选第一个
+		{"arm", "sha256:armv6", true},
+		{"arm/v7", "sha256:armv7", true},
+		{"arm/v5", "", false},
+		{"s390x", "", false},
+	}
+	for _, tt := range tests {
+		desc, ok := SelectManifest(index, tt.arch)
+		if ok != tt.ok || string(desc.Digest) != tt.want {
+			t.Errorf("SelectManifest(%q) = %s, %v, want %s, %v", tt.arch, desc.Digest, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	cacheDir := t.TempDir()
+	layer := populateCache(t, cacheDir, "nginx:1.25")
+
+	ref, _, err := ParseImageRef("nginx:1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := newCacheImageSource(ref, cacheDir)
+
+	result, err := inspectSource(context.Background(), src, "arm64")
+	if err != nil {
+		t.Fatalf("inspectSource() error = %v", err)
+	}
+	if result.Reference != "docker.io/library/nginx:1.25" {
+		t.Errorf("Reference = %s", result.Reference)
+	}
+	if result.MediaType != ocispec.MediaTypeImageIndex || result.ManifestList == nil {
+		t.Errorf("MediaType = %s, ManifestList = %v, want an image index", result.MediaType, result.ManifestList)
+	}
+	if PlatformString(result.Platform) != "linux/arm64" {
+		t.Errorf("Platform = %s, want linux/arm64", PlatformString(result.Platform))
+	}
+	if len(result.Manifest.Layers) != 1 || result.Manifest.Layers[0].Digest != layer {
+		t.Errorf("Manifest.Layers = %v, want [%s]", result.Manifest.Layers, layer)
+	}
+	if result.Config.Architecture != "arm64" || len(result.Config.RootFS.DiffIDs) != 1 {
+		t.Errorf("Config = %+v", result.Config)
+	}
+
+	if _, err := inspectSource(context.Background(), src, "amd64"); !errors.Is(err, ErrPlatformNotFound) {
+		t.Errorf("inspectSource(amd64) error = %v, want ErrPlatformNotFound", err)
+	}
+}
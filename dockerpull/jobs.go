@@ -0,0 +1,320 @@
+package dockerpull
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull 等待中的任务已经达到队列的上限
+var ErrQueueFull = errors.New("job queue is full")
+
+// JobState 任务的状态
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// Job 一个构建 tar 的任务
+type Job struct {
+	ID    string   `json:"id"`
+	Image string   `json:"image"`
+	Arch  string   `json:"arch"`
+	State JobState `json:"state"`
+	Error string   `json:"error,omitempty"`
+
+	Progress JobProgress `json:"progress"`
+	// Result 成功时的下载结果, Output 为 tar 的路径
+	Result *Result `json:"result,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// JobProgress 任务的下载进度, 汇总所有 blob
+type JobProgress struct {
+	Blobs      int   `json:"blobs"`
+	BlobsDone  int   `json:"blobsDone"`
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"totalBytes"`
+}
+
+// Finished 任务已经结束
+func (j Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed
+}
+
+// JobQueueOptions NewJobQueue 的参数
+type JobQueueOptions struct {
+	// Workers 同时运行的任务数, 默认 1
+	Workers int
+	// QueueSize 等待中的任务数上限, 超过时 Submit 返回 ErrQueueFull, 默认 16
+	QueueSize int
+	// HistoryFile 保存任务历史的 JSON 文件, 为空时不保存; 重启时未完成的任务标记为失败
+	HistoryFile string
+	// MaxHistory 保留的已结束任务数, 超过时删除最旧的, 默认 1000
+	MaxHistory int
+
+	// Pull 每个任务的下载参数, Image, Arch 和 Progress 由任务设置
+	Pull Options
+}
+
+// JobQueue 有界的任务队列, 任务通过 Pull 下载并构建 tar
+type JobQueue struct {
+	opts  JobQueueOptions
+	queue chan string
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobQueue 创建任务队列, 读取 HistoryFile 中的任务历史; 调用 Start 之后才开始运行任务
+func NewJobQueue(opts JobQueueOptions) (*JobQueue, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 16
+	}
+	if opts.MaxHistory <= 0 {
+		opts.MaxHistory = 1000
+	}
+
+	q := &JobQueue{
+		opts:  opts,
+		queue: make(chan string, opts.QueueSize),
+		jobs:  make(map[string]*Job),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Start 启动 Workers 个 goroutine 运行任务, ctx 取消后停止, 正在运行的任务失败
+func (q *JobQueue) Start(ctx context.Context) {
+	for i := 0; i < q.opts.Workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-q.queue:
+					q.run(ctx, id)
+				}
+			}
+		}()
+	}
+}
+
+// Submit 添加一个任务; 同一个镜像和平台的任务还没有结束时返回已有的任务
+func (q *JobQueue) Submit(image, arch string) (Job, error) {
+	if arch == "" {
+		arch = "amd64"
+	}
+	if HasTagPattern(image) {
+		return Job{}, fmt.Errorf("version ranges are not supported, use a single tag: %s", image)
+	}
+	if _, _, err := ParseImageRef(image); err != nil {
+		return Job{}, &Error{Op: "parse image", Ref: image, Err: err}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.Image == image && job.Arch == arch && !job.Finished() {
+			return *job, nil
+		}
+	}
+
+	job := &Job{ID: newJobID(), Image: image, Arch: arch, State: JobQueued, CreatedAt: time.Now()}
+	select {
+	case q.queue <- job.ID:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[job.ID] = job
+	q.saveLocked()
+	return *job, nil
+}
+
+// Get 按 ID 查询任务
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List 所有任务, 按创建时间从新到旧
+func (q *JobQueue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+func (q *JobQueue) run(ctx context.Context, id string) {
+	q.mu.Lock()
+	job := q.jobs[id]
+	now := time.Now()
+	job.State = JobRunning
+	job.StartedAt = &now
+	q.saveLocked()
+	image, arch := job.Image, job.Arch
+	q.mu.Unlock()
+
+	opts := q.opts.Pull
+	opts.Image = image
+	opts.Arch = arch
+	opts.Progress = q.progressFunc(job)
+
+	result, err := Pull(ctx, opts)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := time.Now()
+	job.FinishedAt = &finished
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = JobSucceeded
+		job.Result = &result
+	}
+	q.trimLocked()
+	q.saveLocked()
+}
+
+// progressFunc 汇总每个 blob 的进度, blob 是并发下载的
+func (q *JobQueue) progressFunc(job *Job) func(Progress) {
+	type blob struct {
+		current, total int64
+		done           bool
+	}
+	blobs := make(map[string]*blob)
+
+	return func(p Progress) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		b, ok := blobs[p.Digest]
+		if !ok {
+			b = &blob{}
+			blobs[p.Digest] = b
+		}
+		b.current, b.total = p.Current, p.Total
+		b.done = p.Kind == ProgressDone || p.Kind == ProgressCached
+
+		var progress JobProgress
+		for _, b := range blobs {
+			progress.Blobs++
+			if b.done {
+				progress.BlobsDone++
+			}
+			progress.Bytes += b.current
+			if b.total > 0 {
+				progress.TotalBytes += b.total
+			}
+		}
+		job.Progress = progress
+	}
+}
+
+// trimLocked 已结束的任务超过 MaxHistory 时删除最旧的
+func (q *JobQueue) trimLocked() {
+	var finished []*Job
+	for _, job := range q.jobs {
+		if job.Finished() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= q.opts.MaxHistory {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CreatedAt.Before(finished[j].CreatedAt)
+	})
+	for _, job := range finished[:len(finished)-q.opts.MaxHistory] {
+		delete(q.jobs, job.ID)
+	}
+}
+
+// load 读取任务历史, 上次退出时没有结束的任务标记为失败
+func (q *JobQueue) load() error {
+	if q.opts.HistoryFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(q.opts.HistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var jobs []*Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return fmt.Errorf("invalid job history %s: %w", q.opts.HistoryFile, err)
+	}
+	for _, job := range jobs {
+		if !job.Finished() {
+			job.State = JobFailed
+			job.Error = "interrupted by a server restart"
+		}
+		q.jobs[job.ID] = job
+	}
+	return nil
+}
+
+// saveLocked 保存任务历史, 只在状态变化时调用, 进度不保存
+func (q *JobQueue) saveLocked() {
+	if q.opts.HistoryFile == "" {
+		return
+	}
+	jobs := make([]*Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(q.opts.HistoryFile, data)
+	}
+	if err != nil {
+		loggerOrNop(q.opts.Pull.Logger).Errorf("Failed to save job history: %v", err)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
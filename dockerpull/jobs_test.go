@@ -0,0 +1,132 @@
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobHandler(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	populateCache(t, cacheDir, "nginx:1.25")
+	history := filepath.Join(t.TempDir(), "jobs.json")
+
+	q, err := NewJobQueue(JobQueueOptions{
+		HistoryFile: history,
+		Pull:        Options{CacheDir: cacheDir, Offline: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	q.Start(ctx)
+
+	srv := httptest.NewServer(NewJobHandler(q))
+	t.Cleanup(srv.Close)
+
+	do := func(method, path, body string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, data
+	}
+
+	resp, body := do(http.MethodPost, "/api/jobs", `{"image": "nginx:1.25", "arch": "arm64"}`)
+	var job Job
+	json.Unmarshal(body, &job)
+	if resp.StatusCode != http.StatusAccepted || job.ID == "" {
+		t.Fatalf("POST /api/jobs status = %d, body = %s", resp.StatusCode, body)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !job.Finished() {
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish, state = %s", job.State)
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, body = do(http.MethodGet, "/api/jobs/"+job.ID, "")
+		json.Unmarshal(body, &job)
+	}
+	if job.State != JobSucceeded {
+		t.Fatalf("job state = %s, error = %s", job.State, job.Error)
+	}
+	if job.Progress.Blobs != 2 || job.Progress.BlobsDone != 2 {
+		t.Errorf("job progress = %+v, want 2 blobs done", job.Progress)
+	}
+
+	resp, body = do(http.MethodGet, "/api/jobs/"+job.ID+"/download", "")
+	if resp.StatusCode != http.StatusOK || len(body) == 0 || !strings.Contains(resp.Header.Get("Content-Disposition"), ".tar") {
+		t.Errorf("download status = %d, length = %d, disposition = %q", resp.StatusCode, len(body), resp.Header.Get("Content-Disposition"))
+	}
+
+	errorTests := []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"invalid body", http.MethodPost, "/api/jobs", "{", http.StatusBadRequest},
+		{"missing image", http.MethodPost, "/api/jobs", `{"arch": "amd64"}`, http.StatusBadRequest},
+		{"invalid image", http.MethodPost, "/api/jobs", `{"image": "NGINX:1.25"}`, http.StatusBadRequest},
+		{"version range", http.MethodPost, "/api/jobs", `{"image": "nginx:~1.25"}`, http.StatusBadRequest},
+		{"unknown job", http.MethodGet, "/api/jobs/0000/download", "", http.StatusNotFound},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(tt.method, tt.path, tt.body)
+			if resp.StatusCode != tt.status || !strings.Contains(string(body), `"error"`) {
+				t.Errorf("status = %d, body = %s, want %d", resp.StatusCode, body, tt.status)
+			}
+		})
+	}
+
+	// 重启后任务历史还在
+	q2, err := NewJobQueue(JobQueueOptions{HistoryFile: history})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := q2.Get(job.ID); !ok || got.State != JobSucceeded || got.Result == nil {
+		t.Errorf("job after restart = %+v, %v", got, ok)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "jobs.json")
+	q, err := NewJobQueue(JobQueueOptions{QueueSize: 1, HistoryFile: history})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有 Start, 任务一直在队列中
+	first, err := q.Submit("nginx:1.25", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := q.Submit("nginx:1.25", "amd64"); err != nil || again.ID != first.ID {
+		t.Errorf("Submit() of a queued image = %s, %v, want the existing job %s", again.ID, err, first.ID)
+	}
+	if _, err := q.Submit("redis:7", ""); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit() error = %v, want ErrQueueFull", err)
+	}
+
+	// 重启时未完成的任务标记为失败
+	q2, err := NewJobQueue(JobQueueOptions{HistoryFile: history})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := q2.Get(first.ID); got.State != JobFailed || got.Error == "" {
+		t.Errorf("queued job after restart = %+v, want failed", got)
+	}
+}
@@ -0,0 +1,199 @@
+package dockerpull
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// NewJobHandler 任务队列的 REST API 和提交任务的网页
+//
+//	POST /api/jobs                {"image": "nginx:1.25", "arch": "arm64"} 添加任务, 返回 202 和任务
+//	GET  /api/jobs                所有任务
+//	GET  /api/jobs/{id}           任务的状态和进度
+//	GET  /api/jobs/{id}/download  下载成功任务的 tar
+//	GET  /                        提交任务的网页
+func NewJobHandler(q *JobQueue) http.Handler {
+	h := &jobHandler{queue: q}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", h.serveIndex)
+	mux.HandleFunc("POST /api/jobs", h.submit)
+	mux.HandleFunc("GET /api/jobs", h.list)
+	mux.HandleFunc("GET /api/jobs/{id}", h.get)
+	mux.HandleFunc("GET /api/jobs/{id}/download", h.download)
+	return mux
+}
+
+type jobHandler struct {
+	queue *JobQueue
+}
+
+func (h *jobHandler) submit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Image string `json:"image"`
+		Arch  string `json:"arch"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Image == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("image is required"))
+		return
+	}
+
+	job, err := h.queue.Submit(req.Image, req.Arch)
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		w.Header().Set("Retry-After", "30")
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeJSONError(w, http.StatusBadRequest, err)
+	default:
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		writeJSONResponse(w, http.StatusAccepted, job)
+	}
+}
+
+func (h *jobHandler) list(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, h.queue.List())
+}
+
+func (h *jobHandler) get(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.queue.Get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, job)
+}
+
+func (h *jobHandler) download(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.queue.Get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	if job.State != JobSucceeded || job.Result == nil {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("job is %s", job.State))
+		return
+	}
+
+	f, err := os.Open(job.Result.Output)
+	if err != nil {
+		// tar 可能已经被删除或者被之后的下载覆盖
+		writeJSONError(w, http.StatusGone, errors.New("output of the job no longer exists"))
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		writeJSONError(w, http.StatusGone, errors.New("output of the job is not a file"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(job.Result.Output)))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+func (h *jobHandler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(jobIndexHTML))
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSONResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+// jobIndexHTML 提交任务和查看进度的网页, 每 2 秒刷新任务列表
+const jobIndexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>docker-pull</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-top: 1em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.failed { color: #c00; }
+</style>
+</head>
+<body>
+<h1>docker-pull</h1>
+<form id="submit">
+<input name="image" placeholder="nginx:1.25" size="50" required>
+<select name="arch"><option>amd64</option><option>arm64</option></select>
+<button>Pull</button>
+<span id="message"></span>
+</form>
+<table>
+<thead><tr><th>Image</th><th>Arch</th><th>State</th><th>Progress</th><th>Created</th><th></th></tr></thead>
+<tbody id="jobs"></tbody>
+</table>
+<script>
+const form = document.getElementById("submit");
+const message = document.getElementById("message");
+
+form.addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const resp = await fetch("/api/jobs", {
+    method: "POST",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({image: form.image.value, arch: form.arch.value}),
+  });
+  const body = await resp.json();
+  message.textContent = resp.ok ? "" : body.error;
+  refresh();
+});
+
+function mb(n) { return (n / 1048576).toFixed(1) + " MB"; }
+
+function cell(row, text) {
+  const td = row.insertCell();
+  td.textContent = text;
+  return td;
+}
+
+async function refresh() {
+  const jobs = await (await fetch("/api/jobs")).json();
+  const tbody = document.getElementById("jobs");
+  tbody.replaceChildren();
+  for (const job of jobs) {
+    const row = tbody.insertRow();
+    cell(row, job.image);
+    cell(row, job.arch);
+    const state = cell(row, job.state);
+    if (job.error) {
+      state.className = "failed";
+      state.title = job.error;
+    }
+    const p = job.progress;
+    cell(row, p.blobs ? p.blobsDone + "/" + p.blobs + " blobs, " + mb(p.bytes) + " / " + mb(p.totalBytes) : "");
+    cell(row, new Date(job.createdAt).toLocaleString());
+    const link = cell(row, "");
+    if (job.state === "succeeded") {
+      const a = document.createElement("a");
+      a.href = "/api/jobs/" + job.id + "/download";
+      a.textContent = "download";
+      link.appendChild(a);
+    }
+  }
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,211 @@
+package dockerpull
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// podSpec pod 中引用镜像的字段
+type podSpec struct {
+	Containers          []k8sContainer `yaml:"containers"`
+	InitContainers      []k8sContainer `yaml:"initContainers"`
+	EphemeralContainers []k8sContainer `yaml:"ephemeralContainers"`
+}
+
+type k8sContainer struct {
+	Image string `yaml:"image"`
+}
+
+type podTemplate struct {
+	Spec podSpec `yaml:"spec"`
+}
+
+// k8sObject 只解析包含 pod 的资源需要的字段, 不同 kind 的 pod 在不同位置
+type k8sObject struct {
+	Kind string `yaml:"kind"`
+	// PodTemplate 的 template 在顶层
+	Template podTemplate `yaml:"template"`
+	Spec     struct {
+		podSpec     `yaml:",inline"`
+		Template    podTemplate `yaml:"template"`
+		JobTemplate struct {
+			Spec struct {
+				Template podTemplate `yaml:"template"`
+			} `yaml:"spec"`
+		} `yaml:"jobTemplate"`
+	} `yaml:"spec"`
+}
+
+// podSpecs 资源中的 pod, 不包含 pod 的 kind 返回空
+func (o k8sObject) podSpecs() []podSpec {
+	switch o.Kind {
+	case "Pod":
+		return []podSpec{o.Spec.podSpec}
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		return []podSpec{o.Spec.Template.Spec}
+	case "CronJob":
+		return []podSpec{o.Spec.JobTemplate.Spec.Template.Spec}
+	case "PodTemplate":
+		return []podSpec{o.Template.Spec}
+	}
+	return nil
+}
+
+// nodePodSpecs 先只解析 kind, 只有包含 pod 的 kind 才解析其他字段, CRD 等资源的 spec 结构不同, 直接跳过
+func nodePodSpecs(node *yaml.Node) ([]podSpec, error) {
+	// 空文档和不是对象的文档 (如只有注释) 没有镜像
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	var head struct {
+		Kind string `yaml:"kind"`
+	}
+	if err := node.Decode(&head); err != nil {
+		return nil, nil
+	}
+
+	switch head.Kind {
+	case "Pod", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job", "CronJob", "PodTemplate":
+		var obj k8sObject
+		if err := node.Decode(&obj); err != nil {
+			return nil, err
+		}
+		return obj.podSpecs(), nil
+	case "List":
+		var list struct {
+			Items []yaml.Node `yaml:"items"`
+		}
+		if err := node.Decode(&list); err != nil {
+			return nil, err
+		}
+		var specs []podSpec
+		for i := range list.Items {
+			itemSpecs, err := nodePodSpecs(&list.Items[i])
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, itemSpecs...)
+		}
+		return specs, nil
+	}
+	return nil, nil
+}
+
+// KubernetesImages 收集 Kubernetes manifest (可以是 helm template 的输出) 中 Pod, Deployment, StatefulSet, DaemonSet, Job, CronJob 等资源
+// 的 containers, initContainers 和 ephemeralContainers 引用的镜像, 去重后排序返回
+//
+// path 可以是文件, 目录 (递归查找 .yaml 和 .yml, 不能解析的文件如 helm 模板跳过并输出警告) 或 - 表示 stdin; 一个文件中可以有多个 --- 分隔的文档
+func KubernetesImages(path string, log Logger) ([]string, error) {
+	log = loggerOrNop(log)
+	var images imageSet
+
+	if path == "-" {
+		if err := collectKubernetesImages(os.Stdin, &images); err != nil {
+			return nil, fmt.Errorf("stdin: %w", err)
+		}
+		return images.sorted(), nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		if err := collectKubernetesFile(path, &images); err != nil {
+			return nil, err
+		}
+		return images.sorted(), nil
+	}
+
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !(strings.HasSuffix(p, ".yaml") || strings.HasSuffix(p, ".yml")) {
+			return nil
+		}
+		if err := collectKubernetesFile(p, &images); err != nil {
+			var parseErr *k8sParseError
+			if !errors.As(err, &parseErr) {
+				return err
+			}
+			log.Warnf("Skipping %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images.sorted(), nil
+}
+
+// k8sParseError 文件不是合法的 YAML, 例如没有渲染的 helm 模板
+type k8sParseError struct {
+	err error
+}
+
+func (e *k8sParseError) Error() string {
+	return fmt.Sprintf("invalid YAML: %v", e.err)
+}
+
+func (e *k8sParseError) Unwrap() error {
+	return e.err
+}
+
+func collectKubernetesFile(path string, images *imageSet) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := collectKubernetesImages(f, images); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// collectKubernetesImages 解析多文档 YAML, 收集其中的镜像
+func collectKubernetesImages(r io.Reader, images *imageSet) error {
+	dec := yaml.NewDecoder(r)
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return &k8sParseError{err: err}
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+
+		specs, err := nodePodSpecs(node.Content[0])
+		if err != nil {
+			return &k8sParseError{err: err}
+		}
+		for _, spec := range specs {
+			for _, containers := range [][]k8sContainer{spec.InitContainers, spec.Containers, spec.EphemeralContainers} {
+				for _, c := range containers {
+					if c.Image == "" {
+						continue
+					}
+					if strings.Contains(c.Image, "{{") {
+						return &k8sParseError{err: fmt.Errorf("unrendered helm template %q, run helm template first", c.Image)}
+					}
+					if err := images.add(c.Image); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package dockerpull
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKubernetesImages(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("app.yaml", `
+# 注释
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox:1.36
+      containers:
+        - name: web
+          image: nginx:1.25
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - image: docker.io/library/nginx:1.25
+            - image: quay.io/prometheus/prometheus:v2.53.0
+`)
+	write("nested/pod.yml", `
+apiVersion: v1
+kind: Pod
+spec:
+  containers:
+    - image: redis:7
+  ephemeralContainers:
+    - image: busybox:1.36-debug
+`)
+	write("nested/list.yaml", `
+apiVersion: v1
+kind: List
+items:
+  - kind: StatefulSet
+    spec:
+      template:
+        spec:
+          containers:
+            - image: postgres:16
+`)
+	// 没有渲染的 helm 模板和其他文件被跳过
+	write("chart/templates/deployment.yaml", "{{- if .Values.enabled }}\nkind: Deployment\n{{- end }}\n")
+	write("chart/templates/job.yaml", "kind: Job\nspec:\n  template:\n    spec:\n      containers:\n        - image: \"{{ .Values.image }}\"\n")
+	write("chart/values.yaml", "image: mysql:8\n")
+	write("README.md", "image: mysql:8\n")
+
+	images, err := KubernetesImages(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"busybox:1.36", "busybox:1.36-debug", "nginx:1.25", "postgres:16", "quay.io/prometheus/prometheus:v2.53.0", "redis:7"}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("KubernetesImages() = %v, want %v", images, want)
+	}
+
+	// 指定单个文件时不能解析直接报错
+	if _, err := KubernetesImages(filepath.Join(dir, "chart/templates/deployment.yaml"), nil); err == nil {
+		t.Errorf("KubernetesImages() of a helm template error = nil")
+	}
+
+	// 同一个文件中 spec 结构不同的 CRD 不影响其他资源
+	write("crd.yaml", `
+apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+spec:
+  template: main
+  containers: none
+---
+apiVersion: v1
+kind: List
+items:
+  - kind: Rollout
+    spec:
+      template: [1, 2]
+  - kind: DaemonSet
+    spec:
+      template:
+        spec:
+          containers:
+            - image: fluent/fluent-bit:3.0
+---
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - image: alpine:3.20
+`)
+	images, err = KubernetesImages(filepath.Join(dir, "crd.yaml"), nil)
+	if err != nil {
+		t.Fatalf("KubernetesImages() with a CRD error = %v", err)
+	}
+	want = []string{"alpine:3.20", "fluent/fluent-bit:3.0"}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("KubernetesImages() with a CRD = %v, want %v", images, want)
+	}
+}
@@ -0,0 +1,241 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultDockerHost 没有设置 DOCKER_HOST 时 Docker Engine 的地址
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// EngineClient 通过 Docker Engine API 把镜像 load 到 docker 中, 不需要 docker 命令
+type EngineClient struct {
+	client *http.Client
+	base   string
+}
+
+// NewEngineClient 连接 host 指定的 Docker Engine, 支持 unix:// 和 tcp:// (设置 DOCKER_TLS_VERIFY 时使用 TLS); host 为空时使用环境变量 DOCKER_HOST, 再为空时使用 DefaultDockerHost
+func NewEngineClient(host string) (*EngineClient, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultDockerHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		// unix socket 的 URL 中的主机名没有意义, 只用来构造请求
+		return &EngineClient{client: &http.Client{Transport: transport}, base: "http://docker"}, nil
+	case "tcp", "http":
+		// 和 docker 命令一样, 设置了 DOCKER_TLS_VERIFY 时使用 DOCKER_CERT_PATH 中的证书连接
+		if os.Getenv("DOCKER_TLS_VERIFY") != "" {
+			tlsConfig, err := engineTLSConfig(os.Getenv("DOCKER_CERT_PATH"))
+			if err != nil {
+				return nil, err
+			}
+			transport := &http.Transport{TLSClientConfig: tlsConfig}
+			return &EngineClient{client: &http.Client{Transport: transport}, base: "https://" + u.Host}, nil
+		}
+		return &EngineClient{client: &http.Client{}, base: "http://" + u.Host}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q, only unix:// and tcp:// are supported", host)
+	}
+}
+
+// engineTLSConfig 读取 certPath 下的 ca.pem, cert.pem 和 key.pem, certPath 为空时使用 ~/.docker
+func engineTLSConfig(certPath string) (*tls.Config, error) {
+	if certPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("DOCKER_CERT_PATH is not set and the home directory is unknown: %w", err)
+		}
+		certPath = filepath.Join(home, ".docker")
+	}
+
+	caFile := filepath.Join(certPath, "ca.pem")
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker TLS CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load docker TLS client certificate: %w", err)
+	}
+	return &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// engineMessage Docker Engine 返回的 JSON 流中的一条消息
+type engineMessage struct {
+	Stream      string `json:"stream"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// Load 把 docker-archive 格式的 r 上传到 /images/load, 逐条解析返回的 JSON 流, 其中有错误时返回 error
+func (c *EngineClient) Load(ctx context.Context, r io.Reader, log Logger) error {
+	log = loggerOrNop(log)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/images/load", r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to docker engine: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(data, &body) != nil || body.Message == "" {
+			body.Message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("docker engine returned %s: %s", resp.Status, body.Message)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg engineMessage
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid response from docker engine: %w", err)
+		}
+
+		if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+			return fmt.Errorf("docker engine: %s", msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("docker engine: %s", msg.Error)
+		}
+		if s := strings.TrimSpace(msg.Stream); s != "" {
+			log.Infof("%s", s)
+		}
+	}
+}
+
+// loadTar 把 tar 边生成边上传到 Docker Engine, 不写入磁盘
+func (t *TarInfo) loadTar(ctx context.Context, client *EngineClient) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(t.WriteTar(pw))
+	}()
+
+	err := client.Load(ctx, pr, t.logger())
+	// Load 提前返回时让 WriteTar 停止
+	pr.CloseWithError(errors.New("load aborted"))
+	if err != nil {
+		return err
+	}
+
+	t.meta = ArchiveMeta{
+		Reference:          t.Ref.DockerReference().String(),
+		Arch:               t.Arch,
+		Platform:           t.Platform,
+		Squash:             t.Squashed,
+		ManifestDigest:     t.ManifestDigest,
+		PlatformDigest:     t.PlatformDigest,
+		ConfigDigest:       t.ConfigDigest,
+		Layers:             t.Layers,
+		SourceConfigDigest: t.SourceConfigDigest,
+		ArchiveLayers:      t.ArchiveLayers,
+		Created:            time.Now(),
+	}
+	return nil
+}
+
+// WriteTar 直接从 cache 生成和 BuildTar 相同内容的 docker-archive 写入 w, 不使用临时目录
+func (t *TarInfo) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+
+	writeFile := func(name, path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	}
+	writeJSON := func(name string, v any) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	if err := writeFile(t.ConfigDigest+".json", configPath(t.cacheDir(), t.ConfigDigest)); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	for _, layerDigest := range t.LayersDigest {
+		dir := &tar.Header{Name: layerDigest + "/", Mode: 0755, ModTime: now, Typeflag: tar.TypeDir}
+		if err := tw.WriteHeader(dir); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.ToSlash(filepath.Join(layerDigest, "layer.tar")), layerPath(t.cacheDir(), layerDigest)); err != nil {
+			return fmt.Errorf("failed to write layer %s: %w", layerDigest[:12], err)
+		}
+	}
+	if err := writeJSON("repositories", t.repositories()); err != nil {
+		return err
+	}
+	if err := writeJSON("manifest.json", t.archiveManifest()); err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,193 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeEngine 在 unix socket 上模拟 Docker Engine 的 /images/load, 返回 DOCKER_HOST
+func newFakeEngine(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	// unix socket 路径有长度限制, 不使用 t.TempDir
+	dir, err := os.MkdirTemp("", "engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "docker.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix socket is not supported: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return "unix://" + socket
+}
+
+func TestPullLoad(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	layer := populateCache(t, cacheDir, "nginx:1.25")
+
+	var entries []string
+	host := newFakeEngine(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/images/load" {
+			http.Error(w, `{"message": "page not found"}`, http.StatusNotFound)
+			return
+		}
+		tr := tar.NewReader(r.Body)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			entries = append(entries, hdr.Name)
+		}
+		w.Write([]byte(`{"stream":"Loaded image: nginx:1.25\n"}` + "\n"))
+	})
+
+	client, err := NewEngineClient(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Pull(context.Background(), Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: cacheDir, Offline: true, Load: client})
+	if err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+	if !result.Loaded || result.Output != "" {
+		t.Errorf("Loaded = %v, Output = %q, want loaded without output", result.Loaded, result.Output)
+	}
+
+	got := strings.Join(entries, ",")
+	for _, want := range []string{"manifest.json", "repositories", layer.Encoded() + "/layer.tar"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("tar entries = %s, want %s", got, want)
+		}
+	}
+	if _, err := os.Stat("output"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("output directory exists, the tar should not be written to disk")
+	}
+}
+
+func TestEngineClientLoadError(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"error in stream", func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(`{"stream":"Loading layer\n"}` + "\n" + `{"errorDetail":{"message":"no space left on device"},"error":"no space left on device"}` + "\n"))
+		}, "no space left on device"},
+		{"error status", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"message":"client version 1.12 is too old"}`, http.StatusBadRequest)
+		}, "client version 1.12 is too old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewEngineClient(newFakeEngine(t, tt.handler))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = client.Load(context.Background(), strings.NewReader("not a tar"), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewEngineClient("ssh://user@host"); err == nil {
+		t.Errorf("NewEngineClient(ssh://) error = nil, want unsupported")
+	}
+}
+
+// writeTestClientCert 生成自签名的客户端证书, 写入 dir 下的 cert.pem 和 key.pem
+func writeTestClientCert(t *testing.T, dir string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "key.pem"), "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEngineClientTLS(t *testing.T) {
+	var clientCerts int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		clientCerts = len(r.TLS.PeerCertificates)
+		w.Write([]byte(`{"stream":"Loaded image: nginx:1.25\n"}` + "\n"))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	certPath := t.TempDir()
+	writePEM(t, filepath.Join(certPath, "ca.pem"), "CERTIFICATE", srv.Certificate().Raw)
+	writeTestClientCert(t, certPath)
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	t.Setenv("DOCKER_CERT_PATH", certPath)
+
+	client, err := NewEngineClient(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Load(context.Background(), strings.NewReader("tar"), nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if clientCerts != 1 {
+		t.Errorf("client certificates = %d, want 1", clientCerts)
+	}
+
+	// 证书不存在时报错, 不会退回到不加密的连接
+	t.Setenv("DOCKER_CERT_PATH", t.TempDir())
+	if _, err := NewEngineClient(host); err == nil || !strings.Contains(err.Error(), "ca.pem") {
+		t.Errorf("NewEngineClient() without certificates error = %v, want ca.pem error", err)
+	}
+}
@@ -0,0 +1,171 @@
+package dockerpull
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// lockFileVersion 锁文件格式的版本
+const lockFileVersion = 1
+
+// LockFile 锁文件 (如 images.lock), 记录每个镜像解析出的 digest, 之后可以按 digest 下载完全相同的内容
+type LockFile struct {
+	Version int           `json:"version"`
+	Images  []LockedImage `json:"images"`
+}
+
+// LockedImage 一个镜像和平台锁定的 digest
+type LockedImage struct {
+	// Image 下载时使用的镜像名称
+	Image    string `json:"image"`
+	Arch     string `json:"arch"`
+	Platform string `json:"platform,omitempty"`
+	// ManifestDigest tag 指向的 manifest list (或单个 manifest) 的 digest
+	ManifestDigest string `json:"manifestDigest"`
+	// PlatformDigest 平台的 manifest 的 digest, 不是 manifest list 时和 ManifestDigest 相同
+	PlatformDigest string `json:"platformDigest"`
+	ConfigDigest   string `json:"configDigest"`
+}
+
+// LockedImageFromResult 根据下载结果生成锁定的 digest
+func LockedImageFromResult(image string, r Result) LockedImage {
+	return LockedImage{
+		Image:          image,
+		Arch:           r.Arch,
+		Platform:       r.Platform,
+		ManifestDigest: r.ManifestDigest,
+		PlatformDigest: r.PlatformDigest,
+		ConfigDigest:   "sha256:" + r.ConfigDigest,
+	}
+}
+
+// ReadLockFile 读取锁文件
+func ReadLockFile(path string) (*LockFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock LockFile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("invalid lock file %s: %w", path, err)
+	}
+	if lock.Version != lockFileVersion {
+		return nil, fmt.Errorf("unsupported lock file version %d in %s", lock.Version, path)
+	}
+	for _, img := range lock.Images {
+		if img.Image == "" || img.ManifestDigest == "" {
+			return nil, fmt.Errorf("invalid lock file %s: image and manifestDigest are required", path)
+		}
+	}
+	return &lock, nil
+}
+
+// Write 写入锁文件
+func (l *LockFile) Write(path string) error {
+	l.Version = lockFileVersion
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// Find 查找镜像和架构锁定的 digest
+func (l *LockFile) Find(image, arch string) (LockedImage, bool) {
+	for _, img := range l.Images {
+		if img.Image == image && img.Arch == arch {
+			return img, true
+		}
+	}
+	return LockedImage{}, false
+}
+
+// MatchTagPattern 返回锁文件中和 image 同一个仓库, tag 匹配版本范围 (image 的 tag) 或正则, 架构为 arch 的镜像, 按版本排序
+//
+// 用于 -frozen 时的版本范围和 -tag-regex, 不查询远程仓库的 tag, 锁定之后发布的 tag 不会被下载
+func (l *LockFile) MatchTagPattern(image, tagRegex, arch string) ([]string, error) {
+	repo, tag := SplitTag(image)
+	match, err := tagPatternMatcher(tag, tagRegex)
+	if err != nil {
+		return nil, err
+	}
+	name, err := repositoryName(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	var matched []string
+	for _, img := range l.Images {
+		lockedRepo, lockedTag := SplitTag(img.Image)
+		if img.Arch != arch || lockedTag == "" || !match(lockedTag) {
+			continue
+		}
+		// nginx 和 docker.io/library/nginx 是同一个仓库
+		if n, err := repositoryName(lockedRepo); err != nil || n != name {
+			continue
+		}
+		if _, ok := tags[lockedTag]; !ok {
+			tags[lockedTag] = img.Image
+			matched = append(matched, lockedTag)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("%w in lock file: %s %s", ErrNoMatchingTags, repo, strings.TrimSpace(tag+" "+tagRegex))
+	}
+	SortTagsSemVer(matched)
+
+	images := make([]string, 0, len(matched))
+	for _, t := range matched {
+		images = append(images, tags[t])
+	}
+	return images, nil
+}
+
+// repositoryName 规范化的仓库名称, 如 docker.io/library/nginx
+func repositoryName(repo string) (string, error) {
+	ref, _, err := ParseImageRef(repo)
+	if err != nil {
+		return "", err
+	}
+	return ref.DockerReference().Name(), nil
+}
+
+// Set 添加或替换镜像和架构锁定的 digest
+func (l *LockFile) Set(img LockedImage) {
+	for i := range l.Images {
+		if l.Images[i].Image == img.Image && l.Images[i].Arch == img.Arch {
+			l.Images[i] = img
+			return
+		}
+	}
+	l.Images = append(l.Images, img)
+}
+
+// selectPlatformDigest manifest list 中 arch 对应的 manifest 的 digest, 单个 manifest 时为它本身的 digest; 无法确定时返回空
+func selectPlatformDigest(raw []byte, arch string) string {
+	switch manifest.GuessMIMEType(raw) {
+	case manifest.DockerV2ListMediaType, ocispec.MediaTypeImageIndex:
+		var index ocispec.Index
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return ""
+		}
+		if desc, ok := SelectManifest(index, arch); ok {
+			return desc.Digest.String()
+		}
+		return ""
+	default:
+		d, err := manifest.Digest(raw)
+		if err != nil {
+			return ""
+		}
+		return d.String()
+	}
+}
@@ -0,0 +1,138 @@
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPullPinned(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	populateCache(t, cacheDir, "nginx:1.25")
+
+	pull := func(pin *LockedImage) (Result, error) {
+		return Pull(context.Background(), Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: cacheDir, Offline: true, Force: true, Pin: pin})
+	}
+	first, err := pull(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := LockedImageFromResult("nginx:1.25", first)
+	if locked.PlatformDigest == "" || locked.PlatformDigest == locked.ManifestDigest || !strings.HasPrefix(locked.ConfigDigest, "sha256:") {
+		t.Fatalf("locked = %+v, want manifest list, platform and config digests", locked)
+	}
+
+	// tag 指向新的 manifest list
+	ref, _, _ := ParseImageRef("nginx:1.25")
+	raw, err := os.ReadFile(manifestPath(cacheDir, digest.Digest(locked.ManifestDigest)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index ocispec.Index
+	json.Unmarshal(raw, &index)
+	index.Annotations = map[string]string{"rebuilt": "true"}
+	moved, _ := json.Marshal(index)
+	if err := saveManifest(cacheDir, ref.DockerReference(), moved, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := pull(nil); err != nil || result.ManifestDigest == locked.ManifestDigest {
+		t.Fatalf("Pull() = %s, %v, want the moved tag", result.ManifestDigest, err)
+	}
+	result, err := pull(&locked)
+	if err != nil {
+		t.Fatalf("Pull(pinned) error = %v", err)
+	}
+	if result.ManifestDigest != locked.ManifestDigest || result.PlatformDigest != locked.PlatformDigest {
+		t.Errorf("Pull(pinned) = %s %s, want %s %s", result.ManifestDigest, result.PlatformDigest, locked.ManifestDigest, locked.PlatformDigest)
+	}
+
+	missing := locked
+	missing.ManifestDigest = digest.FromString("missing").String()
+	if _, err := pull(&missing); !errors.Is(err, ErrNotInCache) {
+		t.Errorf("Pull(missing digest) error = %v, want ErrNotInCache", err)
+	}
+
+	wrongConfig := locked
+	wrongConfig.ConfigDigest = digest.FromString("config").String()
+	if _, err := pull(&wrongConfig); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Pull(wrong config) error = %v, want ErrIntegrity", err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "images.lock")
+
+	var lock LockFile
+	lock.Set(LockedImage{Image: "nginx:1.25", Arch: "amd64", ManifestDigest: "sha256:1"})
+	lock.Set(LockedImage{Image: "nginx:1.25", Arch: "arm64", ManifestDigest: "sha256:2"})
+	lock.Set(LockedImage{Image: "nginx:1.25", Arch: "amd64", ManifestDigest: "sha256:3"})
+	if err := lock.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadLockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Images) != 2 {
+		t.Errorf("Images = %v, want 2 entries", read.Images)
+	}
+	if img, ok := read.Find("nginx:1.25", "amd64"); !ok || img.ManifestDigest != "sha256:3" {
+		t.Errorf("Find() = %+v, %v", img, ok)
+	}
+	if _, ok := read.Find("redis:7", "amd64"); ok {
+		t.Errorf("Find() of a missing image = true")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version": 2, "images": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadLockFile(path); err == nil {
+		t.Errorf("ReadLockFile() of an unknown version error = nil")
+	}
+}
+
+func TestLockFileMatchTagPattern(t *testing.T) {
+	var lock LockFile
+	for _, img := range []LockedImage{
+		{Image: "nginx:1.25.3", Arch: "amd64"},
+		{Image: "nginx:1.25.10", Arch: "amd64"},
+		{Image: "nginx:1.25.3", Arch: "arm64"},
+		{Image: "nginx:1.26.0", Arch: "amd64"},
+		{Image: "docker.io/library/nginx:1.25.4", Arch: "amd64"},
+		{Image: "redis:1.25.1", Arch: "amd64"},
+		{Image: "nginx:1.25.3-alpine", Arch: "amd64"},
+	} {
+		lock.Set(img)
+	}
+
+	tests := []struct {
+		image, tagRegex, arch string
+		want                  []string
+	}{
+		// 同一个仓库的不同写法都匹配, 按版本排序; 其他仓库和架构不匹配
+		{"nginx:~1.25", "", "amd64", []string{"nginx:1.25.3", "docker.io/library/nginx:1.25.4", "nginx:1.25.10"}},
+		{"docker.io/library/nginx:~1.25", "", "arm64", []string{"nginx:1.25.3"}},
+		{"nginx", `-alpine$`, "amd64", []string{"nginx:1.25.3-alpine"}},
+	}
+	for _, tt := range tests {
+		got, err := lock.MatchTagPattern(tt.image, tt.tagRegex, tt.arch)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MatchTagPattern(%q, %q, %q) = %v, %v, want %v", tt.image, tt.tagRegex, tt.arch, got, err, tt.want)
+		}
+	}
+
+	if _, err := lock.MatchTagPattern("nginx:~1.27", "", "amd64"); !errors.Is(err, ErrNoMatchingTags) {
+		t.Errorf("MatchTagPattern() of a range not in the lock file error = %v, want ErrNoMatchingTags", err)
+	}
+}
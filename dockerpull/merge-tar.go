@@ -0,0 +1,112 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// MergeArchives 把多个 docker-archive 合并为一个, 一次 docker load 即可加载所有镜像
+//
+// 各个 tar 的 manifest.json 和 repositories 合并, 多个镜像共用的 layer 和 config 只保留一份
+func MergeArchives(w io.Writer, archives []string) error {
+	tw := tar.NewWriter(w)
+	seen := make(map[string]bool)
+	var manifests []Schema2Manifest
+	repositories := make(map[string]map[string]string)
+
+	for _, archive := range archives {
+		err := mergeArchive(tw, archive, seen, func(name string, r io.Reader) error {
+			switch name {
+			case "manifest.json":
+				var m []Schema2Manifest
+				if err := json.NewDecoder(r).Decode(&m); err != nil {
+					return err
+				}
+				manifests = append(manifests, m...)
+			case "repositories":
+				var repos map[string]map[string]string
+				if err := json.NewDecoder(r).Decode(&repos); err != nil {
+					return err
+				}
+				for repo, tags := range repos {
+					if repositories[repo] == nil {
+						repositories[repo] = make(map[string]string)
+					}
+					for tag, id := range tags {
+						repositories[repo][tag] = id
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to merge %s: %w", archive, err)
+		}
+	}
+	if len(manifests) == 0 {
+		return errors.New("no images to merge")
+	}
+
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		v    any
+	}{{"repositories", repositories}, {"manifest.json", manifests}} {
+		data, err := json.MarshalIndent(f.v, "", "  ")
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// mergeArchive 复制一个 tar 中还没有复制过的文件, manifest.json 和 repositories 交给 meta 处理
+func mergeArchive(tw *tar.Writer, archive string, seen map[string]bool, meta func(name string, r io.Reader) error) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Name == "manifest.json" || hdr.Name == "repositories" {
+			if err := meta(hdr.Name, tr); err != nil {
+				return fmt.Errorf("invalid %s: %w", hdr.Name, err)
+			}
+			continue
+		}
+		// layer 和 config 按 digest 命名, 同名即相同内容
+		if seen[hdr.Name] {
+			continue
+		}
+		seen[hdr.Name] = true
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package dockerpull
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrNotInCache 离线模式下需要的 manifest 或 blob 不在 cache 中
+var ErrNotInCache = errors.New("not found in cache")
+
+// manifestPath cache 中 manifest 的路径: <cacheDir>/manifests/<hex>/manifest.json
+func manifestPath(cacheDir string, d digest.Digest) string {
+	return filepath.Join(cacheDir, "manifests", d.Encoded(), "manifest.json")
+}
+
+// tagPath cache 中 tag 指向的 manifest digest: <cacheDir>/refs/<domain>/<path>/_tags/<tag>
+//
+// 仓库路径的每一段都以字母或数字开头, _tags 不会和仓库路径冲突; 不带 tag 的引用返回空字符串
+// windows 的文件名不能有 :, 域名中的端口写为 host_5000
+func tagPath(cacheDir string, named reference.Named) string {
+	tagged, ok := named.(reference.Tagged)
+	if !ok {
+		return ""
+	}
+	domain := strings.ReplaceAll(reference.Domain(named), ":", "_")
+	return filepath.Join(cacheDir, "refs", domain, filepath.FromSlash(reference.Path(named)), "_tags", tagged.Tag())
+}
+
+// saveManifest 把在线下载的 manifest 保存到 cache, 供离线模式使用; tag 不为空时同时记录 tag 指向的 digest
+func saveManifest(cacheDir string, named reference.Named, raw []byte, isTag bool) error {
+	d, err := manifest.Digest(raw)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(manifestPath(cacheDir, d), raw); err != nil {
+		return err
+	}
+	if p := tagPath(cacheDir, named); isTag && p != "" {
+		return writeFileAtomic(p, []byte(d.String()))
+	}
+	return nil
+}
+
+// writeFileAtomic 先写临时文件再改名, 其他进程不会读到不完整的文件
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// windows 上目标文件存在时不能改名
+	_ = os.Remove(path)
+	return os.Rename(tmp.Name(), path)
+}
+
+// cacheImageSource 只从 cache 读取 manifest 和 blob 的 ImageSource, 用于离线模式
+type cacheImageSource struct {
+	ref      types.ImageReference
+	cacheDir string
+}
+
+func newCacheImageSource(ref types.ImageReference, cacheDir string) *cacheImageSource {
+	return &cacheImageSource{ref: ref, cacheDir: cacheDir}
+}
+
+func (s *cacheImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *cacheImageSource) Close() error {
+	return nil
+}
+
+// GetManifest instanceDigest 为空时按引用中的 digest 或 tag 查找
+func (s *cacheImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	named := s.ref.DockerReference()
+
+	var d digest.Digest
+	switch {
+	case instanceDigest != nil:
+		d = *instanceDigest
+	case isDigested(named):
+		d = named.(reference.Digested).Digest()
+	default:
+		p := tagPath(s.cacheDir, named)
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: tag %s was never pulled online", ErrNotInCache, named)
+		}
+		d, err = digest.Parse(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cached tag %s: %w", p, err)
+		}
+	}
+
+	raw, err := os.ReadFile(manifestPath(s.cacheDir, d))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: manifest %s", ErrNotInCache, d)
+	}
+	if d.Algorithm().FromBytes(raw) != d {
+		return nil, "", fmt.Errorf("%w: cached manifest %s", ErrIntegrity, d)
+	}
+	return raw, manifest.GuessMIMEType(raw), nil
+}
+
+func isDigested(named reference.Named) bool {
+	_, ok := named.(reference.Digested)
+	return ok
+}
+
+// GetBlob blob 不在 cache 中时返回 ErrNotInCache; 已在 cache 中的 blob 在 downloadBlob 中直接使用, 不会调用这里
+func (s *cacheImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	for _, p := range []string{layerPath(s.cacheDir, info.Digest.Encoded()), configPath(s.cacheDir, info.Digest.Encoded())} {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, fi.Size(), nil
+	}
+	return nil, 0, fmt.Errorf("%w: blob %s", ErrNotInCache, info.Digest)
+}
+
+func (s *cacheImageSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+func (s *cacheImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	return nil, nil
+}
+
+func (s *cacheImageSource) LayerInfosForCopy(ctx context.Context, instanceDigest *digest.Digest) ([]types.BlobInfo, error) {
+	return nil, nil
+}
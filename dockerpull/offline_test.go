@@ -0,0 +1,123 @@
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// populateCache 模拟一次在线下载后的 cache: manifest list, arm64 的 manifest, config 和 layer
+func populateCache(t *testing.T, cacheDir, image string) (layer digest.Digest) {
+	t.Helper()
+
+	writeBlob := func(path string, data []byte) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustJSON := func(v any) []byte {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	layerData := []byte("layer")
+	layer = digest.FromBytes(layerData)
+	writeBlob(layerPath(cacheDir, layer.Encoded()), layerData)
+
+	config := []byte(`{"architecture":"arm64","os":"linux","rootfs":{"type":"layers","diff_ids":["` + layer.String() + `"]}}`)
+	configDigest := digest.FromBytes(config)
+	writeBlob(configPath(cacheDir, configDigest.Encoded()), config)
+
+	man := mustJSON(manifest.Schema2{
+		SchemaVersion:     2,
+		MediaType:         manifest.DockerV2Schema2MediaType,
+		ConfigDescriptor:  manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2ConfigMediaType, Digest: configDigest, Size: int64(len(config))},
+		LayersDescriptors: []manifest.Schema2Descriptor{{MediaType: manifest.DockerV2Schema2LayerMediaType, Digest: layer, Size: int64(len(layerData))}},
+	})
+	list := mustJSON(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{{
+			MediaType: manifest.DockerV2Schema2MediaType,
+			Digest:    digest.FromBytes(man),
+			Size:      int64(len(man)),
+			Platform:  &ocispec.Platform{OS: "linux", Architecture: "arm64"},
+		}},
+	})
+
+	ref, _, err := ParseImageRef(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveManifest(cacheDir, ref.DockerReference(), list, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveManifest(cacheDir, ref.DockerReference(), man, false); err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestPullOffline(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	populateCache(t, cacheDir, "nginx:1.25")
+
+	result, err := Pull(context.Background(), Options{
+		Image:    "nginx:1.25",
+		Arch:     "arm64",
+		CacheDir: cacheDir,
+		Offline:  true,
+	})
+	if err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+	if !FileExists(result.Output) {
+		t.Errorf("output %s does not exist", result.Output)
+	}
+	if result.Downloads != 0 || result.CacheHits != 2 {
+		t.Errorf("Downloads = %d, CacheHits = %d, want everything from cache", result.Downloads, result.CacheHits)
+	}
+}
+
+func TestPullOfflineMissing(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	layer := populateCache(t, cacheDir, "nginx:1.25")
+
+	tests := []struct {
+		name  string
+		image string
+		setup func()
+	}{
+		{name: "tag never pulled", image: "nginx:1.26"},
+		{name: "blob missing", image: "nginx:1.25", setup: func() {
+			os.Remove(layerPath(cacheDir, layer.Encoded()))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			_, err := Pull(context.Background(), Options{Image: tt.image, Arch: "arm64", CacheDir: cacheDir, Offline: true})
+			if !errors.Is(err, ErrNotInCache) || Classify(err) != ClassNotFound {
+				t.Errorf("Pull() error = %v, want ErrNotInCache", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package dockerpull
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+)
+
+// DefaultTagTTL 代理模式下 tag 指向的 digest 默认的缓存时间
+const DefaultTagTTL = 5 * time.Minute
+
+// ProxyOptions NewProxyHandler 的参数
+type ProxyOptions struct {
+	// CacheDir 缓存目录, 为空时使用 DefaultCacheDir
+	CacheDir string
+
+	// Upstream 仓库名称不带域名时使用的上游 registry, 如 harbor.example.com:5000, 为空时为 Docker Hub
+	Upstream string
+
+	// Proxy 访问上游 registry 使用的代理
+	Proxy *url.URL
+	// SystemContext 不为空时代替根据 Proxy 创建的 SystemContext, 可以设置鉴权, TLS 等
+	SystemContext *types.SystemContext
+
+	// TagTTL tag 指向的 digest 在这个时间内直接使用 cache, 超过后向上游确认 (HEAD 请求, 不计入 Docker Hub 的次数);
+	// 0 表示每次都确认; 上游不可用时使用 cache 中过期的结果
+	TagTTL time.Duration
+
+	// Logger 日志, 为空时不输出日志
+	Logger Logger
+}
+
+// NewProxyHandler pull-through 代理: 接口同 NewRegistryHandler, cache 中没有的 manifest 和 blob 从上游 registry 获取,
+// 校验 digest 后写入 cache, 同时返回给客户端
+//
+// 仓库名称不带域名时上游为 Upstream (默认 Docker Hub), 如 <地址>/nginx:1.25; 带域名时为对应的 registry, 如 <地址>/quay.io/prometheus/prometheus
+func NewProxyHandler(opts ProxyOptions) http.Handler {
+	if opts.CacheDir == "" {
+		opts.CacheDir = DefaultCacheDir()
+	}
+	sysCtx := opts.SystemContext
+	if sysCtx == nil {
+		sysCtx = NewSystemContext(opts.Proxy)
+	}
+	log := loggerOrNop(opts.Logger)
+
+	return &registryHandler{
+		cacheDir: opts.CacheDir,
+		log:      log,
+		upstream: &upstream{
+			cacheDir: opts.CacheDir,
+			registry: strings.TrimSuffix(opts.Upstream, "/"),
+			sysCtx:   sysCtx,
+			tagTTL:   opts.TagTTL,
+			log:      log,
+			blobs:    make(map[digest.Digest]blobOwner),
+		},
+	}
+}
+
+type upstream struct {
+	cacheDir string
+	registry string
+	sysCtx   *types.SystemContext
+	tagTTL   time.Duration
+	log      Logger
+
+	mu sync.Mutex
+	// blobs 返回过的 manifest 中的 blob
+	blobs map[digest.Digest]blobOwner
+}
+
+// blobOwner 引用 blob 的 manifest; 上游的 ImageSource 创建时会读取 manifest, 所以需要用引用 blob 的 manifest 创建
+type blobOwner struct {
+	manifest digest.Digest
+	// config 为 true 时保存到 config 目录, 否则保存到 layers 目录
+	config bool
+}
+
+// image 客户端请求的仓库名称对应的上游仓库
+func (u *upstream) image(name string) string {
+	if u.registry == "" || hasDomain(name) {
+		return name
+	}
+	return u.registry + "/" + name
+}
+
+// resolveTag tag 指向的 manifest digest, 过期时向上游确认, 变化时下载新的 manifest
+func (u *upstream) resolveTag(ctx context.Context, name, tag string) (digest.Digest, error) {
+	ref, _, err := ParseImageRef(u.image(name) + ":" + tag)
+	if err != nil {
+		return "", err
+	}
+	p := tagPath(u.cacheDir, ref.DockerReference())
+
+	cached, cachedAt, ok := readCachedTag(u.cacheDir, p)
+	if ok && time.Since(cachedAt) < u.tagTTL {
+		return cached, nil
+	}
+
+	remote, err := docker.GetDigest(ctx, u.sysCtx, ref)
+	if err != nil {
+		if ok {
+			u.log.Warnf("Failed to resolve %s:%s upstream, serving cached %s: %v", name, tag, cached, err)
+			return cached, nil
+		}
+		return "", err
+	}
+	if ok && remote == cached {
+		touchBlob(p)
+		return cached, nil
+	}
+
+	src, err := ref.NewImageSource(ctx, u.sysCtx)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	raw, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := saveManifest(u.cacheDir, ref.DockerReference(), raw, true); err != nil {
+		return "", err
+	}
+	d := digest.FromBytes(raw)
+	u.log.Infof("Fetched manifest %s:%s (%s)", name, tag, d)
+	return d, nil
+}
+
+// readCachedTag 读取 cache 中 tag 指向的 digest 和保存时间, manifest 不在 cache 中时视为没有
+func readCachedTag(cacheDir, p string) (digest.Digest, time.Time, bool) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	d, err := digest.Parse(strings.TrimSpace(string(raw)))
+	if err != nil || !FileExists(manifestPath(cacheDir, d)) {
+		return "", time.Time{}, false
+	}
+	return d, fi.ModTime(), true
+}
+
+// fetchManifest 从上游下载指定 digest 的 manifest, 校验后保存到 cache
+func (u *upstream) fetchManifest(ctx context.Context, name string, d digest.Digest) ([]byte, error) {
+	ref, _, err := ParseImageRef(u.image(name) + "@" + d.String())
+	if err != nil {
+		return nil, err
+	}
+	src, err := ref.NewImageSource(ctx, u.sysCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	raw, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.Algorithm().FromBytes(raw) != d {
+		return nil, fmt.Errorf("%w: manifest %s", ErrIntegrity, d)
+	}
+	if err := saveManifest(u.cacheDir, ref.DockerReference(), raw, false); err != nil {
+		return nil, err
+	}
+	u.log.Infof("Fetched manifest %s@%s", name, d)
+	return raw, nil
+}
+
+// rememberManifest 记录 manifest 中的 config 和 layer, 客户端总是先获取 manifest 再获取 blob
+func (u *upstream) rememberManifest(raw []byte, mimeType string, d digest.Digest) {
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return
+	}
+	man, err := manifest.FromBlob(raw, mimeType)
+	if err != nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, layer := range man.LayerInfos() {
+		u.blobs[layer.Digest] = blobOwner{manifest: d}
+	}
+	u.blobs[man.ConfigInfo().Digest] = blobOwner{manifest: d, config: true}
+}
+
+func (u *upstream) blobOwner(d digest.Digest) (blobOwner, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	owner, ok := u.blobs[d]
+	return owner, ok
+}
+
+// serveBlob 从上游下载 blob, 同时写入 cache 和返回给客户端
+//
+// 和下载一样持有 blob 的锁, 其他请求同一个 blob 的客户端等待下载完成后从 cache 读取;
+// 客户端断开时继续下载, 保证 cache 中有完整的 blob; 带 Range 的请求先下载完整的 blob 再返回
+func (u *upstream) serveBlob(w http.ResponseWriter, r *http.Request, name string, d digest.Digest, h *registryHandler) {
+	owner, ok := u.blobOwner(d)
+	if !ok {
+		h.serveError(w, r, v2.ErrorCodeBlobUnknown.WithMessage("blob is not referenced by any manifest fetched through the proxy").WithDetail(d.String()))
+		return
+	}
+	ref, _, err := ParseImageRef(u.image(name) + "@" + owner.manifest.String())
+	if err != nil {
+		h.serveError(w, r, v2.ErrorCodeNameInvalid.WithDetail(name))
+		return
+	}
+	// 客户端断开时不取消上游的下载
+	ctx := context.WithoutCancel(r.Context())
+
+	src, err := ref.NewImageSource(ctx, u.sysCtx)
+	if err != nil {
+		h.serveError(w, r, upstreamError(err, v2.ErrorCodeBlobUnknown.WithDetail(d.String())))
+		return
+	}
+	defer src.Close()
+
+	path := layerPath(u.cacheDir, d.Encoded())
+	if owner.config {
+		path = configPath(u.cacheDir, d.Encoded())
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		h.serveError(w, r, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+		return
+	}
+	lock, err := acquireLock(path+".lock", func() {
+		u.log.Infof("Waiting for another request to fetch blob %s", d)
+	})
+	if err != nil {
+		h.serveError(w, r, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+		return
+	}
+	locked := true
+	unlock := func() {
+		if locked {
+			lock.Unlock()
+			locked = false
+		}
+	}
+	defer unlock()
+
+	// 等待锁的时候其他请求已经下载完成
+	if h.serveCachedBlob(w, r, d) {
+		return
+	}
+
+	blob, size, err := src.GetBlob(ctx, types.BlobInfo{Digest: d, Size: -1}, none.NoCache)
+	if err != nil {
+		h.serveError(w, r, upstreamError(err, v2.ErrorCodeBlobUnknown.WithDetail(d.String())))
+		return
+	}
+	defer blob.Close()
+
+	if r.Method == http.MethodHead {
+		if size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", d.String())
+		return
+	}
+
+	var client io.Writer = io.Discard
+	if r.Header.Get("Range") == "" {
+		if size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.WriteHeader(http.StatusOK)
+		client = &clientWriter{w: w}
+	}
+
+	n, err := writeBlobFile(path, d, io.TeeReader(blob, client))
+	if err != nil {
+		u.log.Errorf("Failed to fetch blob %s: %v", d, err)
+		if client == io.Discard {
+			h.serveError(w, r, upstreamError(err, v2.ErrorCodeBlobUnknown.WithDetail(d.String())))
+			return
+		}
+		// 已经开始返回内容, 断开连接让客户端知道 blob 不完整
+		panic(http.ErrAbortHandler)
+	}
+	u.log.Infof("Fetched blob %s (%d bytes)", d, n)
+
+	if client == io.Discard {
+		unlock()
+		h.serveCachedBlob(w, r, d)
+	}
+}
+
+// writeBlobFile 写入临时文件, 校验 digest 后改名为 path
+func writeBlobFile(path string, d digest.Digest, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	verifier := d.Verifier()
+	n, err := io.Copy(io.MultiWriter(tmp, verifier), r)
+	if err != nil {
+		return n, err
+	}
+	if !verifier.Verified() {
+		return n, fmt.Errorf("%w: digest mismatch", ErrIntegrity)
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// clientWriter 写给客户端, 客户端断开后忽略错误, 不影响写入 cache
+type clientWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+func (c *clientWriter) Write(b []byte) (int, error) {
+	if !c.failed {
+		if _, err := c.w.Write(b); err != nil {
+			c.failed = true
+		}
+	}
+	return len(b), nil
+}
+
+// listTags 上游的 tag 列表
+func (u *upstream) listTags(ctx context.Context, name string) ([]string, error) {
+	return ListTags(ctx, u.image(name), u.sysCtx)
+}
+
+// upstreamError 把上游的错误转换为返回给客户端的错误
+func upstreamError(err error, notFound errcode.Error) error {
+	switch Classify(err) {
+	case ClassNotFound, ClassUnauthorized:
+		// 上游对不存在的仓库也可能返回 401
+		return notFound
+	case ClassRateLimited:
+		return errcode.ErrorCodeTooManyRequests.WithDetail(err.Error())
+	default:
+		return errcode.ErrorCodeUnavailable.WithDetail(err.Error())
+	}
+}
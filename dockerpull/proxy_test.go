@@ -0,0 +1,93 @@
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestProxyHandler(t *testing.T) {
+	upstreamReg, upstreamHost := newMemoryRegistry(t)
+	list := seedRegistry(t, upstreamReg)
+
+	cacheDir := t.TempDir()
+	insecure := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+	handler := NewProxyHandler(ProxyOptions{CacheDir: cacheDir, Upstream: upstreamHost, SystemContext: insecure, TagTTL: time.Hour})
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	image := strings.TrimPrefix(srv.URL, "http://") + "/library/nginx:1.25"
+	pull := func() {
+		t.Helper()
+		_, err := Pull(context.Background(), Options{Image: image, Arch: "arm64", CacheDir: t.TempDir(), SystemContext: insecure})
+		if err != nil {
+			t.Fatalf("Pull() error = %v", err)
+		}
+	}
+	t.Chdir(t.TempDir())
+
+	pull()
+
+	// manifest 和 blob 已经保存到代理的 cache, config 和 layer 在各自的目录
+	var index ocispec.Index
+	json.Unmarshal(list, &index)
+	raw := upstreamReg.manifests[index.Manifests[0].Digest.String()]
+	man, _ := manifest.FromBlob(raw, manifest.DockerV2Schema2MediaType)
+	for _, p := range []string{
+		manifestPath(cacheDir, digest.FromBytes(list)),
+		manifestPath(cacheDir, index.Manifests[0].Digest),
+		configPath(cacheDir, man.ConfigInfo().Digest.Encoded()),
+		layerPath(cacheDir, man.LayerInfos()[0].Digest.Encoded()),
+	} {
+		if !FileExists(p) {
+			t.Errorf("%s is not cached", p)
+		}
+	}
+
+	// 上游不可用时从 cache 返回
+	upstreamReg.down = true
+	pull()
+}
+
+func TestProxyHandlerTagTTL(t *testing.T) {
+	upstreamReg, upstreamHost := newMemoryRegistry(t)
+	seedRegistry(t, upstreamReg)
+
+	cacheDir := t.TempDir()
+	insecure := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+	srv := httptest.NewServer(NewProxyHandler(ProxyOptions{CacheDir: cacheDir, Upstream: upstreamHost, SystemContext: insecure}))
+	t.Cleanup(srv.Close)
+
+	image := strings.TrimPrefix(srv.URL, "http://") + "/library/nginx:1.25"
+	resolve := func() string {
+		t.Helper()
+		d, err := RemoteManifestDigest(context.Background(), image, insecure)
+		if err != nil {
+			t.Fatalf("RemoteManifestDigest() error = %v", err)
+		}
+		return d
+	}
+
+	first := resolve()
+
+	// TTL 为 0 时每次都向上游确认, tag 更新后返回新的 manifest
+	updated := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+	upstreamReg.manifests["1.25"] = updated
+	if got := resolve(); got != digest.FromBytes(updated).String() || got == first {
+		t.Errorf("after the tag moved upstream, digest = %s, want %s", got, digest.FromBytes(updated))
+	}
+
+	// 上游不可用时返回 cache 中过期的结果
+	upstreamReg.down = true
+	if got := resolve(); got != digest.FromBytes(updated).String() {
+		t.Errorf("with the upstream down, digest = %s, want the cached %s", got, digest.FromBytes(updated))
+	}
+}
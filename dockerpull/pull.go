@@ -0,0 +1,669 @@
+// Package dockerpull 通过 registry v2 接口下载镜像, 组装为可以 docker load 的 tar, 不依赖 docker
+//
+//	result, err := dockerpull.Pull(ctx, dockerpull.Options{
+//		Image:  "nginx:1.25",
+//		Arch:   "arm64",
+//		Logger: logrus.New(),
+//	})
+//	if err != nil {
+//		var e *dockerpull.Error
+//		if errors.As(err, &e) { ... }
+//	}
+//	fmt.Println(result.Output)
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/fatih/color"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Options Pull 的参数
+type Options struct {
+	// Image 镜像名称, 如 nginx:1.25, docker.io/library/nginx:latest, myregistry.com:5000/myproject/myapp:v1.0
+	Image string
+	// Arch cpu架构, 可以带 variant, 如 arm/v7; 默认 amd64
+	Arch string
+	// Proxy 访问 registry 使用的代理
+	Proxy *url.URL
+	// SystemContext 不为空时代替根据 Proxy 创建的 SystemContext, 可以设置鉴权, TLS 等
+	SystemContext *types.SystemContext
+
+	// Export 不为空时导出扁平化的根文件系统而不是构造 docker-archive, 以 .tar 结尾输出为单个 tar, 否则输出为目录
+	Export string
+	// Squash 把所有 layer 合并为一个 layer 后再打包
+	Squash bool
+	// Pin 不为空时按锁定的 digest 下载, 不使用 tag 当前指向的 manifest; digest 不存在或内容不一致时返回错误
+	Pin *LockedImage
+	// Load 不为空时把 tar 边生成边 load 到 Docker Engine, 不写入 output 目录; 和 Export 不能同时使用
+	Load *EngineClient
+	// Force 忽略已有的 tar, 强制重新构建
+	Force bool
+	// SkipExisting 同一个 config 的 tar 已存在时跳过, 批量下载多个 tag 时使用
+	SkipExisting bool
+	// RateLimitWait 被 registry 限流 (HTTP 429) 时最多等待多久后重试, 为 0 时直接返回 *RateLimitError
+	RateLimitWait time.Duration
+	// CacheDir 保存 layer 和 config 的目录, 为空时使用 DefaultCacheDir; 多个进程可以同时使用同一个目录
+	CacheDir string
+	// Offline 不访问 registry, 只用 cache 中的 manifest 和 blob 构建, 缺少时返回 ErrNotInCache
+	Offline bool
+
+	// Bandwidth 所有 blob 下载共享的限速, 为空时不限速; 下载多个镜像时传入同一个 limiter 即可限制总速率
+	Bandwidth *BandwidthLimiter
+	// ConnRate 每个 blob 下载连接的限速 (字节/秒), 为 0 时不限速
+	ConnRate int64
+
+	// Logger 日志, 为空时不输出日志
+	Logger Logger
+	// Progress 下载进度回调, blob 是并发下载的, 回调可能在多个 goroutine 中同时调用
+	Progress func(Progress)
+}
+
+// ProgressKind 进度事件的类型
+type ProgressKind string
+
+const (
+	// ProgressCached blob 已经在 cache 中, 不需要下载
+	ProgressCached ProgressKind = "cached"
+	// ProgressStart 开始下载 blob
+	ProgressStart ProgressKind = "start"
+	// ProgressDownloading 下载中, Current 为已下载的字节数
+	ProgressDownloading ProgressKind = "downloading"
+	// ProgressDone blob 下载完成
+	ProgressDone ProgressKind = "done"
+)
+
+// Progress 一个 blob 的下载进度
+type Progress struct {
+	Kind    ProgressKind
+	Digest  string
+	Current int64
+	Total   int64
+}
+
+// Result 一个镜像的下载结果
+type Result struct {
+	ArchiveMeta
+
+	// Output 输出的 tar 或 rootfs 路径
+	Output string `json:"output"`
+	// Skipped 输出已是最新, 本次没有重新构建
+	Skipped bool `json:"skipped,omitempty"`
+	// Loaded 已经 load 到 Docker Engine, 此时 Output 为空
+	Loaded bool `json:"loaded,omitempty"`
+
+	CacheHits       int   `json:"cacheHits"`
+	Downloads       int   `json:"downloads"`
+	DownloadedBytes int64 `json:"downloadedBytes"`
+
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// NewSystemContext 创建访问 registry 使用的 SystemContext
+func NewSystemContext(proxy *url.URL) *types.SystemContext {
+	return &types.SystemContext{
+		DockerProxyURL: proxy, // 设置代理
+	}
+}
+
+// ParseImageRef 解析镜像名称, 自动补全 docker transport 需要的 // 前缀
+func ParseImageRef(image string) (types.ImageReference, DockerImageV2, error) {
+	// 创建 Docker 引用
+	if !strings.HasPrefix(image, "//") {
+		image = "//" + image
+	}
+	return ParseImageInfoV2(image)
+}
+
+// Pull 下载镜像的 manifest, config 和 layer 到 cache, 并构造可以 docker load 的 tar (或导出 rootfs)
+func Pull(ctx context.Context, opts Options) (Result, error) {
+	startedAt := time.Now()
+
+	if opts.Arch == "" {
+		opts.Arch = "amd64"
+	}
+	if opts.CacheDir == "" {
+		opts.CacheDir = DefaultCacheDir()
+	}
+	sysCtx := opts.SystemContext
+	if sysCtx == nil {
+		sysCtx = NewSystemContext(opts.Proxy)
+	}
+
+	ref, imageinfo, err := ParseImageRef(opts.Image)
+	if err != nil {
+		return Result{}, &Error{Op: "parse image", Ref: opts.Image, Err: err}
+	}
+	refName := ref.DockerReference().String()
+
+	log := loggerOrNop(opts.Logger)
+	limiter := &rateLimitWaiter{
+		ctx:      ctx,
+		image:    opts.Image,
+		sysCtx:   sysCtx,
+		log:      log,
+		deadline: time.Now().Add(opts.RateLimitWait),
+	}
+
+	// 2. 创建镜像源, 离线时从 cache 读取
+	var src types.ImageSource
+	if opts.Offline {
+		src = newCacheImageSource(ref, opts.CacheDir)
+	} else {
+		// 创建镜像源时就会请求 manifest, 被限流时也需要等待
+		for {
+			src, err = ref.NewImageSource(ctx, sysCtx)
+			if err == nil {
+				break
+			}
+			if err := limiter.wait(err); err != nil {
+				return Result{}, &Error{Op: "create image source", Ref: refName, Err: err}
+			}
+		}
+	}
+	defer src.Close()
+
+	d := &Downloader{
+		ref:       ref,
+		src:       src,
+		sysCtx:    sysCtx,
+		ctx:       ctx,
+		imageInfo: imageinfo,
+		opts:      opts,
+		log:       log,
+		limiter:   limiter,
+		startedAt: startedAt,
+		cached:    make(map[string]bool),
+	}
+	return d.pull()
+}
+
+type Downloader struct {
+	ref       types.ImageReference
+	src       types.ImageSource
+	sysCtx    *types.SystemContext
+	ctx       context.Context
+	imageInfo DockerImageV2
+
+	// 远程 manifest (或 manifest list) 的 digest
+	manifestDigest string
+	// 选择的平台的 manifest 的 digest, 不是 manifest list 时和 manifestDigest 相同
+	platformDigest string
+
+	opts    Options
+	log     Logger
+	limiter *rateLimitWaiter
+
+	startedAt time.Time
+
+	// 记录每个 blob 是否命中 cache, 下载是并发的, 需要加锁
+	mu              sync.Mutex
+	cached          map[string]bool
+	downloadedBytes int64
+}
+
+func (d *Downloader) pull() (Result, error) {
+	refName := d.ref.DockerReference().String()
+
+	// 锁定时按 digest 获取, 即使 tag 已经指向其他 manifest
+	var pinned *digest.Digest
+	if d.opts.Pin != nil {
+		pd, err := digest.Parse(d.opts.Pin.ManifestDigest)
+		if err != nil {
+			return Result{}, &Error{Op: "parse locked digest", Ref: refName, Err: err}
+		}
+		pinned = &pd
+		d.log.Infof("Using locked manifest %s", pd)
+	}
+
+	// 3. 获取原始 manifest 字节
+	rawManifest, err := d.getManifest(pinned)
+	if err != nil {
+		return Result{}, &Error{Op: "get manifest", Ref: refName, Err: err}
+	}
+
+	// 4. 解析 manifest
+	digest, err := manifest.Digest(rawManifest)
+	if err != nil {
+		return Result{}, &Error{Op: "compute digest", Ref: refName, Err: err}
+	}
+	d.manifestDigest = digest.String()
+	if pinned != nil && digest != *pinned {
+		return Result{}, &Error{Op: "verify manifest", Ref: refName, Digest: pinned.String(), Err: fmt.Errorf("%w: got %s", ErrIntegrity, digest)}
+	}
+
+	mediaType := manifest.GuessMIMEType(rawManifest)
+	d.log.Infof("Manifest Digest: %s", digest)
+	d.log.Infof("Media Type: %s", mediaType)
+
+	// 远程 manifest 没有变化时, 不需要重新构建
+	if !d.opts.Force && d.opts.Export == "" && d.opts.Load == nil {
+		existing, meta, ok := findUpToDateArchive(d.log, d.imageInfo, ArchiveMeta{
+			Reference:      refName,
+			Arch:           d.opts.Arch,
+			Squash:         d.opts.Squash,
+			ManifestDigest: d.manifestDigest,
+		})
+		if ok {
+			// 之前版本生成的元数据中没有平台 manifest 的 digest
+			if meta.PlatformDigest == "" {
+				meta.PlatformDigest = selectPlatformDigest(rawManifest, d.opts.Arch)
+			}
+			d.log.Infof("%s", color.HiYellowString("Tar is up to date, skipping: %s", existing))
+			return Result{
+				ArchiveMeta: meta,
+				Output:      existing,
+				Skipped:     true,
+				StartedAt:   d.startedAt,
+				DurationMs:  time.Since(d.startedAt).Milliseconds(),
+			}, nil
+		}
+	}
+
+	platform := &ocispec.Platform{OS: "linux", Architecture: d.opts.Arch}
+
+	// 5. 根据媒体类型解析具体 manifest
+	switch mediaType {
+	case manifest.DockerV2Schema2MediaType, ocispec.MediaTypeImageManifest:
+		// 不是 manifest list, 直接使用
+		d.platformDigest = d.manifestDigest
+
+	case manifest.DockerV2ListMediaType, ocispec.MediaTypeImageIndex:
+		// docker manifest list 和 oci index 结构相同, 统一按 oci index 解析
+		var index ocispec.Index
+		if err := json.Unmarshal(rawManifest, &index); err != nil {
+			return Result{}, &Error{Op: "unmarshal manifest list", Ref: refName, Err: err}
+		}
+
+		desc, ok := SelectManifest(index, d.opts.Arch)
+		if !ok {
+			return Result{}, &Error{Op: "select manifest", Ref: refName, Err: fmt.Errorf("%w: linux/%s", ErrPlatformNotFound, d.opts.Arch)}
+		}
+		platform = desc.Platform
+		d.platformDigest = desc.Digest.String()
+		if d.opts.Pin != nil && d.opts.Pin.PlatformDigest != "" && d.platformDigest != d.opts.Pin.PlatformDigest {
+			return Result{}, &Error{Op: "select manifest", Ref: refName, Err: fmt.Errorf("%w: locked manifest %s for linux/%s is not in the manifest list", ErrPlatformNotFound, d.opts.Pin.PlatformDigest, d.opts.Arch)}
+		}
+
+		d.log.Infof("Downloading manifest for %s: %s", PlatformString(platform), desc.Digest)
+
+		// raw是字节数组， 第二个是 content type
+		rawManifest, err = d.getManifest(&desc.Digest)
+		if err != nil {
+			return Result{}, &Error{Op: "get manifest", Ref: refName, Digest: desc.Digest.String(), Err: err}
+		}
+
+	default:
+		return Result{}, &Error{Op: "parse manifest", Ref: refName, Err: fmt.Errorf("%w: %s", ErrUnsupportedManifest, mediaType)}
+	}
+
+	// 解析为 Docker Schema 2, oci manifest 结构相同
+	var man manifest.Schema2
+	if err := json.Unmarshal(rawManifest, &man); err != nil {
+		return Result{}, &Error{Op: "unmarshal manifest", Ref: refName, Err: err}
+	}
+	if d.opts.Pin != nil && d.opts.Pin.ConfigDigest != "" && man.ConfigDescriptor.Digest.String() != d.opts.Pin.ConfigDigest {
+		return Result{}, &Error{Op: "verify config", Ref: refName, Digest: d.opts.Pin.ConfigDigest, Err: fmt.Errorf("%w: got %s", ErrIntegrity, man.ConfigDescriptor.Digest)}
+	}
+
+	return d.pullManifest(man, platform)
+}
+
+// pullManifest 下载单个平台的 config 和 layer, 然后构造 tar
+func (d *Downloader) pullManifest(man manifest.Schema2, platform *ocispec.Platform) (Result, error) {
+	refName := d.ref.DockerReference().String()
+
+	tarInfo := &TarInfo{
+		Ref:            d.ref,
+		ImageInfo:      d.imageInfo,
+		ManifestDigest: d.manifestDigest,
+		PlatformDigest: d.platformDigest,
+		ConfigDigest:   strings.TrimPrefix(man.ConfigDescriptor.Digest.String(), "sha256:"),
+		Arch:           d.opts.Arch,
+		Platform:       PlatformString(platform),
+		CacheDir:       d.opts.CacheDir,
+		LayersDigest: func() []string {
+			var layers []string
+			for _, layer := range man.LayersDescriptors {
+				layers = append(layers, strings.TrimPrefix(layer.Digest.String(), "sha256:"))
+			}
+			return layers
+		}(),
+		log: d.log,
+	}
+
+	// 同一个 config 的 tar 已经存在, 不需要重新下载; 结果使用 tar 旁边的元数据, 元数据不存在或者 tar 不完整时重新构建
+	if d.opts.SkipExisting && !d.opts.Force && d.opts.Export == "" && d.opts.Load == nil && !d.opts.Squash {
+		existing := tarInfo.buildTarName()
+		if meta, err := ReadArchiveMeta(archiveMetaPath(existing)); err == nil && archiveComplete(existing, meta) {
+			d.log.Infof("%s", color.HiYellowString("Tar already exists, skipping: %s", existing))
+			return Result{
+				ArchiveMeta: meta,
+				Output:      existing,
+				Skipped:     true,
+				StartedAt:   d.startedAt,
+				DurationMs:  time.Since(d.startedAt).Milliseconds(),
+			}, nil
+		}
+	}
+
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(man.LayersDescriptors)+1)
+
+	// 下载 config
+	d.downloadConfigBlob(man.ConfigDescriptor, &wg, errChan)
+
+	// 下载 layers
+	d.downloadLayersBlob(man.LayersDescriptors, &wg, errChan)
+
+	wg.Wait()
+
+	// 收集所有错误
+	close(errChan)
+	var errs []error
+	for err := range errChan {
+		if err != nil {
+			d.log.Errorf("Error occurred: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return Result{}, errors.Join(errs...)
+	}
+
+	for _, layer := range man.LayersDescriptors {
+		tarInfo.Layers = append(tarInfo.Layers, LayerReport{
+			Digest:    layer.Digest.String(),
+			Size:      layer.Size,
+			MediaType: layer.MediaType,
+			Cached:    d.cached[layer.Digest.String()],
+		})
+	}
+
+	if d.opts.Export != "" {
+		// 导出 rootfs
+		if err := tarInfo.ExportRootfs(d.opts.Export); err != nil {
+			return Result{}, &Error{Op: "export rootfs", Ref: refName, Err: err}
+		}
+	} else {
+		if d.opts.Squash {
+			if err := tarInfo.Squash(); err != nil {
+				return Result{}, &Error{Op: "squash", Ref: refName, Err: err}
+			}
+		}
+		if d.opts.Load != nil {
+			if err := tarInfo.loadTar(d.ctx, d.opts.Load); err != nil {
+				return Result{}, &Error{Op: "load image", Ref: refName, Err: err}
+			}
+		} else {
+			// 构造tar包
+			if err := tarInfo.BuildTar(); err != nil {
+				return Result{}, &Error{Op: "build tar", Ref: refName, Err: err}
+			}
+		}
+	}
+
+	return d.buildResult(tarInfo), nil
+}
+
+// buildResult 汇总一个平台的下载结果
+func (d *Downloader) buildResult(tarInfo *TarInfo) Result {
+	result := Result{
+		ArchiveMeta: tarInfo.meta,
+		Output:      tarInfo.output,
+		StartedAt:   d.startedAt,
+	}
+
+	result.Loaded = d.opts.Load != nil
+
+	// 导出 rootfs 时没有经过 packTar
+	if d.opts.Export != "" {
+		result.ArchiveMeta = ArchiveMeta{
+			Reference:      d.ref.DockerReference().String(),
+			Arch:           tarInfo.Arch,
+			Platform:       tarInfo.Platform,
+			ManifestDigest: tarInfo.ManifestDigest,
+			PlatformDigest: tarInfo.PlatformDigest,
+			ConfigDigest:   tarInfo.ConfigDigest,
+			Layers:         tarInfo.Layers,
+			Created:        time.Now(),
+		}
+		result.Output = d.opts.Export
+		if strings.HasSuffix(d.opts.Export, ".tar") {
+			result.Sha256, _ = FileSHA256(d.opts.Export)
+		}
+	}
+
+	d.mu.Lock()
+	for _, cached := range d.cached {
+		if cached {
+			result.CacheHits++
+		} else {
+			result.Downloads++
+		}
+	}
+	result.DownloadedBytes = d.downloadedBytes
+	d.mu.Unlock()
+
+	result.DurationMs = time.Since(d.startedAt).Milliseconds()
+	return result
+}
+
+func (d *Downloader) downloadLayersBlob(schema2Descriptor []manifest.Schema2Descriptor, wg *sync.WaitGroup, errChan chan error) {
+	for _, desc := range schema2Descriptor {
+		d.log.Infof("%s", color.HiCyanString("Downloading layers %s", strings.TrimPrefix(desc.Digest.String(), "sha256:")[:16]))
+
+		wg.Add(1)
+		go func(errChan chan error) {
+			defer wg.Done()
+
+			err := d.downloadBlob(desc, SaveProps{
+				path: "layers",
+				name: "layer.tar",
+			})
+			if err != nil {
+				errChan <- err
+			}
+		}(errChan)
+	}
+}
+
+func (d *Downloader) downloadConfigBlob(configDescriptor manifest.Schema2Descriptor, wg *sync.WaitGroup, errChan chan error) {
+
+	d.log.Infof("%s", color.HiCyanString("Downloading config %s", strings.TrimPrefix(configDescriptor.Digest.String(), "sha256:")[:16]))
+
+	wg.Add(1)
+	go func(errChan chan error) {
+		defer wg.Done()
+		err := d.downloadBlob(configDescriptor, SaveProps{
+			path: "config",
+			name: "config.json",
+		})
+		if err != nil {
+			errChan <- err
+		}
+	}(errChan)
+}
+
+// getManifest 获取 manifest, 被限流时在 RateLimitWait 内等待后重试
+func (d *Downloader) getManifest(instance *digest.Digest) ([]byte, error) {
+	for {
+		raw, _, err := d.src.GetManifest(d.ctx, instance)
+		if err == nil {
+			if !d.opts.Offline {
+				// 保存到 cache, 之后可以离线构建
+				if serr := saveManifest(d.opts.CacheDir, d.ref.DockerReference(), raw, instance == nil); serr != nil {
+					d.log.Warnf("Failed to save manifest to cache: %v", serr)
+				}
+			}
+			return raw, nil
+		}
+		if err := d.limiter.wait(err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type SaveProps struct {
+	path string
+	name string
+}
+
+func (d *Downloader) downloadBlob(desc manifest.Schema2Descriptor, saveProps SaveProps) error {
+
+	// 创建 blob 文件夹
+	blobPath := filepath.Join(d.opts.CacheDir, saveProps.path, strings.TrimPrefix(desc.Digest.String(), "sha256:"))
+
+	blobErr := func(op string, err error) error {
+		return &Error{Op: op, Ref: d.ref.DockerReference().String(), Digest: desc.Digest.String(), Err: err}
+	}
+
+	err := os.MkdirAll(blobPath, 0755)
+	if err != nil {
+		return blobErr("create folder", err)
+	}
+
+	// blob 文件
+	tarFilePath := filepath.Join(blobPath, saveProps.name)
+
+	// 检查文件是否已存在
+	if d.blobCached(desc, tarFilePath) {
+		return nil
+	}
+
+	// 其他进程可能正在下载同一个 blob, 等它下载完成
+	lock, err := acquireLock(tarFilePath+".lock", func() {
+		d.log.Infof("Waiting for another process downloading blob: %s", desc.Digest)
+	})
+	if err != nil {
+		return blobErr("lock blob", err)
+	}
+	defer lock.Unlock()
+
+	if d.blobCached(desc, tarFilePath) {
+		return nil
+	}
+
+	// 先写临时文件, 下载并校验完成后再改名, 其他进程不会读到不完整的文件
+	tmpFile, err := os.CreateTemp(blobPath, saveProps.name+".*.tmp")
+	if err != nil {
+		return blobErr("create blob file", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	// 获取 blob 读取器
+	blobReader, size, err := d.src.GetBlob(d.ctx, types.BlobInfo{
+		Digest: desc.Digest,
+		Size:   desc.Size,
+	}, none.NoCache)
+
+	if err != nil {
+		return blobErr("get blob", err)
+	}
+	defer blobReader.Close()
+
+	d.progress(Progress{Kind: ProgressStart, Digest: desc.Digest.String(), Total: size})
+
+	// 限速: 全局的令牌桶和每个连接的令牌桶都要满足
+	var reader io.Reader = blobReader
+	if d.opts.Bandwidth != nil || d.opts.ConnRate > 0 {
+		reader = &limitedReader{
+			ctx:      d.ctx,
+			r:        blobReader,
+			limiters: []*BandwidthLimiter{d.opts.Bandwidth, NewBandwidthLimiter(d.opts.ConnRate)},
+		}
+	}
+
+	// 复制 blob 内容, 同时计算 digest
+	verifier := desc.Digest.Verifier()
+	copied, err := io.Copy(io.MultiWriter(tmpFile, verifier), &progressReader{
+		r: reader,
+		fn: func(n int64) {
+			d.progress(Progress{Kind: ProgressDownloading, Digest: desc.Digest.String(), Current: n, Total: size})
+		},
+	})
+	if err != nil {
+		return blobErr("copy blob", err)
+	}
+
+	// 验证大小和 digest
+	if size >= 0 && copied != size {
+		return blobErr("verify blob", fmt.Errorf("%w: size mismatch, expected %d, got %d", ErrIntegrity, size, copied))
+	}
+	if !verifier.Verified() {
+		return blobErr("verify blob", fmt.Errorf("%w: digest mismatch", ErrIntegrity))
+	}
+
+	// windows 上需要先关闭文件才能改名
+	if err := tmpFile.Close(); err != nil {
+		return blobErr("write blob", err)
+	}
+	if err := os.Rename(tmpFile.Name(), tarFilePath); err != nil {
+		return blobErr("write blob", err)
+	}
+
+	d.log.Infof("  Successfully downloaded blob: %s (%d bytes)", desc.Digest, copied)
+	d.recordBlob(desc.Digest.String(), false, copied)
+	d.progress(Progress{Kind: ProgressDone, Digest: desc.Digest.String(), Current: copied, Total: size})
+	return nil
+}
+
+// blobCached blob 已经在 cache 中时记录 cache 命中
+func (d *Downloader) blobCached(desc manifest.Schema2Descriptor, path string) bool {
+	if !FileExists(path) {
+		return false
+	}
+	d.log.Infof("Blob already exists, skipping: %s", desc.Digest)
+	touchBlob(path)
+	d.recordBlob(desc.Digest.String(), true, 0)
+	d.progress(Progress{Kind: ProgressCached, Digest: desc.Digest.String(), Current: desc.Size, Total: desc.Size})
+	return true
+}
+
+// recordBlob 记录 blob 是否命中 cache 以及下载的字节数
+func (d *Downloader) recordBlob(digest string, cached bool, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cached[digest] = cached
+	d.downloadedBytes += size
+}
+
+func (d *Downloader) progress(p Progress) {
+	if d.opts.Progress != nil {
+		d.opts.Progress(p)
+	}
+}
+
+// progressReader 读取时回调已读取的总字节数
+type progressReader struct {
+	r  io.Reader
+	n  int64
+	fn func(n int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if n > 0 {
+		p.fn(p.n)
+	}
+	return n, err
+}
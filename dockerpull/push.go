@@ -0,0 +1,290 @@
+package dockerpull
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/archive"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// PushOptions Push 的参数
+type PushOptions struct {
+	// Source docker-archive 格式的 tar (docker save 或本工具的输出), 或者 OCI layout 目录
+	Source string
+	// Destination 目标镜像, 如 myregistry.com:5000/myproject/myapp:v1.0
+	Destination string
+
+	// Proxy 访问 registry 使用的代理
+	Proxy *url.URL
+	// SystemContext 不为空时代替根据 Proxy 创建的 SystemContext, 可以设置鉴权, TLS 等
+	SystemContext *types.SystemContext
+	// CacheDir 压缩 layer 时的临时文件放在 <CacheDir>/tmp 下, 为空时使用 DefaultCacheDir
+	CacheDir string
+
+	// Logger 日志, 为空时不输出日志
+	Logger Logger
+}
+
+// TransferStats 上传 blob 的统计
+type TransferStats struct {
+	// Uploaded 上传的 blob 数, Skipped registry 中已经存在而跳过的 blob 数
+	Uploaded      int   `json:"uploaded"`
+	Skipped       int   `json:"skipped"`
+	UploadedBytes int64 `json:"uploadedBytes"`
+}
+
+// PushResult 一次 push 的结果
+type PushResult struct {
+	Destination    string `json:"destination"`
+	ManifestDigest string `json:"manifestDigest"`
+	TransferStats
+	DurationMs int64 `json:"durationMs"`
+}
+
+// NewSourceReference 根据路径创建本地镜像的引用: 目录为 OCI layout, 文件为 docker-archive
+func NewSourceReference(path string) (types.ImageReference, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return layout.NewReference(path, "")
+	}
+	return archive.NewReference(path, nil)
+}
+
+// Push 把本地的 docker-archive 或 OCI layout 推送到 registry
+//
+// registry 中已经存在的 blob (HEAD 请求) 跳过; 未压缩的 layer 先用 gzip 压缩再上传
+func Push(ctx context.Context, opts PushOptions) (PushResult, error) {
+	startedAt := time.Now()
+	log := loggerOrNop(opts.Logger)
+	result := PushResult{Destination: opts.Destination}
+
+	if opts.CacheDir == "" {
+		opts.CacheDir = DefaultCacheDir()
+	}
+	sysCtx := opts.SystemContext
+	if sysCtx == nil {
+		sysCtx = NewSystemContext(opts.Proxy)
+	}
+
+	pushErr := func(op string, err error) error {
+		return &Error{Op: op, Ref: opts.Destination, Err: err}
+	}
+
+	srcRef, err := NewSourceReference(opts.Source)
+	if err != nil {
+		return result, &Error{Op: "open source", Ref: opts.Source, Err: err}
+	}
+	src, err := srcRef.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		return result, &Error{Op: "open source", Ref: opts.Source, Err: err}
+	}
+	defer src.Close()
+
+	dstRef, err := docker.ParseReference("//" + opts.Destination)
+	if err != nil {
+		return result, pushErr("parse destination", err)
+	}
+	dest, err := dstRef.NewImageDestination(ctx, sysCtx)
+	if err != nil {
+		return result, pushErr("create image destination", err)
+	}
+	defer dest.Close()
+
+	rawManifest, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return result, &Error{Op: "get manifest", Ref: opts.Source, Err: err}
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return result, pushErr("push", fmt.Errorf("%w: pushing a manifest list is not supported, use a single-platform image", ErrUnsupportedManifest))
+	}
+	man, err := manifest.FromBlob(rawManifest, mimeType)
+	if err != nil {
+		return result, &Error{Op: "parse manifest", Ref: opts.Source, Err: err}
+	}
+
+	p := &pusher{ctx: ctx, src: src, dest: dest, log: log, tmpDir: filepath.Join(opts.CacheDir, "tmp"), stats: &result.TransferStats}
+
+	// config
+	if err := p.copyBlob(man.ConfigInfo(), true); err != nil {
+		return result, pushErr("push config", err)
+	}
+
+	// layers
+	var layerInfos []types.BlobInfo
+	for _, layer := range man.LayerInfos() {
+		info, err := p.pushLayer(layer.BlobInfo)
+		if err != nil {
+			return result, &Error{Op: "push layer", Ref: opts.Destination, Digest: layer.Digest.String(), Err: err}
+		}
+		layerInfos = append(layerInfos, info)
+	}
+
+	// 压缩过的 layer 的 digest 和大小变了, 需要更新 manifest
+	if err := man.UpdateLayerInfos(layerInfos); err != nil {
+		return result, pushErr("update manifest", err)
+	}
+	newManifest, err := man.Serialize()
+	if err != nil {
+		return result, pushErr("serialize manifest", err)
+	}
+	if err := dest.PutManifest(ctx, newManifest, nil); err != nil {
+		return result, pushErr("put manifest", err)
+	}
+	if err := dest.Commit(ctx, image.UnparsedInstance(src, nil)); err != nil {
+		return result, pushErr("commit", err)
+	}
+
+	d, err := manifest.Digest(newManifest)
+	if err != nil {
+		return result, pushErr("compute digest", err)
+	}
+	result.ManifestDigest = d.String()
+	result.DurationMs = time.Since(startedAt).Milliseconds()
+	log.Infof("Pushed %s (%s)", opts.Destination, d)
+	return result, nil
+}
+
+type pusher struct {
+	ctx    context.Context
+	src    types.ImageSource
+	dest   types.ImageDestination
+	log    Logger
+	tmpDir string
+	stats  *TransferStats
+}
+
+// exists 通过 HEAD 请求判断 registry 中是否已经有这个 blob
+func (p *pusher) exists(info types.BlobInfo) (bool, error) {
+	ok, _, err := p.dest.TryReusingBlob(p.ctx, info, none.NoCache, false)
+	if err != nil || !ok {
+		return false, err
+	}
+	p.log.Infof("Blob already exists, skipping: %s", info.Digest)
+	p.stats.Skipped++
+	return true, nil
+}
+
+func (p *pusher) put(r io.Reader, info types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	uploaded, err := p.dest.PutBlob(p.ctx, r, info, none.NoCache, isConfig)
+	if err != nil {
+		return uploaded, err
+	}
+	p.log.Infof("  Successfully uploaded blob: %s (%d bytes)", uploaded.Digest, uploaded.Size)
+	p.stats.Uploaded++
+	p.stats.UploadedBytes += uploaded.Size
+	return uploaded, nil
+}
+
+// copyBlob 原样上传一个 blob, digest 不变
+func (p *pusher) copyBlob(info types.BlobInfo, isConfig bool) error {
+	if ok, err := p.exists(info); ok || err != nil {
+		return err
+	}
+	r, size, err := p.src.GetBlob(p.ctx, info, none.NoCache)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = p.put(r, types.BlobInfo{Digest: info.Digest, Size: size, MediaType: info.MediaType}, isConfig)
+	return err
+}
+
+// pushLayer 上传一个 layer, 返回 manifest 中应该使用的 BlobInfo
+func (p *pusher) pushLayer(info types.BlobInfo) (types.BlobInfo, error) {
+	r, size, err := p.src.GetBlob(p.ctx, info, none.NoCache)
+	if err != nil {
+		return info, err
+	}
+	defer r.Close()
+
+	decompressor, stream, err := compression.DetectCompression(r)
+	if err != nil {
+		return info, err
+	}
+
+	// 已经压缩的 layer 原样上传
+	if decompressor != nil {
+		blob := types.BlobInfo{Digest: info.Digest, Size: size, MediaType: info.MediaType}
+		if ok, err := p.exists(blob); ok || err != nil {
+			return blob, err
+		}
+		return p.put(stream, blob, false)
+	}
+
+	// docker-archive 中的 layer 是未压缩的, 先压缩到临时文件, 得到 digest 之后才能判断 registry 中是否已经存在
+	tmp, blob, err := p.gzipLayer(stream)
+	if err != nil {
+		return info, fmt.Errorf("failed to compress layer: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	blob.CompressionOperation = types.Compress
+	blob.CompressionAlgorithm = &compression.Gzip
+
+	if ok, err := p.exists(blob); ok || err != nil {
+		return blob, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return blob, err
+	}
+	uploaded, err := p.put(tmp, types.BlobInfo{Digest: blob.Digest, Size: blob.Size}, false)
+	if err != nil {
+		return blob, err
+	}
+	blob.Digest, blob.Size = uploaded.Digest, uploaded.Size
+	return blob, nil
+}
+
+func (p *pusher) gzipLayer(r io.Reader) (*os.File, types.BlobInfo, error) {
+	if err := os.MkdirAll(p.tmpDir, 0755); err != nil {
+		return nil, types.BlobInfo{}, err
+	}
+	tmp, err := os.CreateTemp(p.tmpDir, "push-*.tar.gz")
+	if err != nil {
+		return nil, types.BlobInfo{}, err
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, hash)}
+	gz := gzip.NewWriter(counter)
+	if _, err := io.Copy(gz, r); err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, types.BlobInfo{}, err
+	}
+
+	return tmp, types.BlobInfo{Digest: digest.NewDigest(digest.SHA256, hash), Size: counter.n}, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,175 @@
+package dockerpull
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// memoryRegistry 在内存中保存 blob 和 manifest 的 registry, 支持上传和下载
+type memoryRegistry struct {
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	uploads   map[string][]byte
+	manifests map[string][]byte
+	puts      int
+	// down 为 true 时所有请求返回 500, 模拟 registry 不可用
+	down bool
+}
+
+func newMemoryRegistry(t *testing.T) (*memoryRegistry, string) {
+	t.Helper()
+
+	reg := &memoryRegistry{
+		blobs:     make(map[digest.Digest][]byte),
+		uploads:   make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(reg.serveHTTP))
+	t.Cleanup(srv.Close)
+	return reg, strings.TrimPrefix(srv.URL, "https://")
+}
+
+func (reg *memoryRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case reg.down:
+		w.WriteHeader(http.StatusInternalServerError)
+
+	case path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+
+	case strings.Contains(path, "/blobs/uploads/"):
+		id := path[strings.LastIndex(path, "/")+1:]
+		switch r.Method {
+		case http.MethodPost:
+			id = fmt.Sprintf("upload-%d", len(reg.uploads))
+			reg.uploads[id] = nil
+			w.Header().Set("Location", "/v2/test/app/blobs/uploads/"+id)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPatch:
+			data, _ := io.ReadAll(r.Body)
+			reg.uploads[id] = append(reg.uploads[id], data...)
+			w.Header().Set("Location", "/v2/test/app/blobs/uploads/"+id)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			data = append(reg.uploads[id], data...)
+			d := digest.Digest(r.URL.Query().Get("digest"))
+			if digest.FromBytes(data) != d {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reg.blobs[d] = data
+			reg.puts++
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.WriteHeader(http.StatusCreated)
+		}
+
+	case strings.Contains(path, "/blobs/"):
+		d := digest.Digest(path[strings.LastIndex(path, "/")+1:])
+		data, ok := reg.blobs[d]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	case strings.Contains(path, "/manifests/") && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		reg.manifests[path[strings.LastIndex(path, "/")+1:]] = data
+		reg.manifests[digest.FromBytes(data).String()] = data
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.WriteHeader(http.StatusCreated)
+
+	case strings.Contains(path, "/manifests/"):
+		data, ok := reg.manifests[path[strings.LastIndex(path, "/")+1:]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", manifest.GuessMIMEType(data))
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPush(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	populateCache(t, cacheDir, "nginx:1.25")
+
+	pulled, err := Pull(context.Background(), Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: cacheDir, Offline: true})
+	if err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	reg, host := newMemoryRegistry(t)
+	opts := PushOptions{
+		Source:        pulled.Output,
+		Destination:   host + "/test/app:v1",
+		SystemContext: &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue},
+		CacheDir:      cacheDir,
+	}
+
+	result, err := Push(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if result.Uploaded != 2 || result.Skipped != 0 {
+		t.Errorf("Uploaded = %d, Skipped = %d, want 2 uploaded", result.Uploaded, result.Skipped)
+	}
+
+	raw, ok := reg.manifests["v1"]
+	if !ok {
+		t.Fatal("manifest was not pushed")
+	}
+	if got := digest.FromBytes(raw).String(); got != result.ManifestDigest {
+		t.Errorf("ManifestDigest = %s, want %s", result.ManifestDigest, got)
+	}
+	man, err := manifest.FromBlob(raw, manifest.GuessMIMEType(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range man.LayerInfos() {
+		if _, ok := reg.blobs[layer.Digest]; !ok {
+			t.Errorf("layer %s referenced by the manifest was not uploaded", layer.Digest)
+		}
+		if !strings.HasSuffix(layer.MediaType, "gzip") {
+			t.Errorf("layer media type = %s, want gzip", layer.MediaType)
+		}
+	}
+
+	// 第二次推送时 blob 已经存在, 只推送 manifest
+	result, err = Push(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("second Push() error = %v", err)
+	}
+	if result.Uploaded != 0 || result.Skipped != 2 || reg.puts != 2 {
+		t.Errorf("second push: Uploaded = %d, Skipped = %d, blob uploads = %d, want everything skipped", result.Uploaded, result.Skipped, reg.puts)
+	}
+}
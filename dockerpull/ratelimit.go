@@ -0,0 +1,385 @@
+package dockerpull
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"github.com/containers/image/v5/types"
+)
+
+// RateLimit registry 返回的限流配额, Docker Hub 通过 ratelimit-limit / ratelimit-remaining 响应头返回
+type RateLimit struct {
+	// Limit 窗口内允许的 pull 次数, -1 表示 registry 没有返回
+	Limit int `json:"limit"`
+	// Remaining 窗口内剩余的 pull 次数, -1 表示 registry 没有返回
+	Remaining int `json:"remaining"`
+	// Window 限流窗口, Docker Hub 为 6 小时
+	Window time.Duration `json:"window"`
+	// RetryAfter 被限流时 registry 建议的等待时间, 可能为 0
+	RetryAfter time.Duration `json:"retryAfter,omitempty"`
+}
+
+// Known registry 是否返回了限流信息
+func (r RateLimit) Known() bool {
+	return r.Limit >= 0 && r.Remaining >= 0
+}
+
+func (r RateLimit) String() string {
+	if !r.Known() {
+		return "unknown"
+	}
+	s := fmt.Sprintf("%d/%d", r.Remaining, r.Limit)
+	if r.Window > 0 {
+		s += " per " + r.Window.String()
+	}
+	return s
+}
+
+// RateLimitError 被 registry 限流 (HTTP 429), Classify 返回 ClassRateLimited
+type RateLimitError struct {
+	Limit RateLimit
+	Err   error
+}
+
+func (e *RateLimitError) Error() string {
+	msg := "registry rate limit exceeded"
+	if e.Limit.Known() {
+		msg += fmt.Sprintf(", %s pulls remaining", e.Limit)
+	}
+	if e.Limit.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %s", e.Limit.RetryAfter)
+	}
+	return msg
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// CheckRateLimit 用 HEAD 请求镜像的 manifest 读取限流配额, Docker Hub 的 HEAD 请求不计入 pull 次数
+func CheckRateLimit(ctx context.Context, image string, sysCtx *types.SystemContext) (RateLimit, error) {
+	ref, _, err := ParseImageRef(image)
+	if err != nil {
+		return unknownRateLimit(), err
+	}
+	named := ref.DockerReference()
+
+	domain := reference.Domain(named)
+	host := domain
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	manifestRef := "latest"
+	if tagged, ok := named.(reference.Tagged); ok {
+		manifestRef = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		manifestRef = digested.Digest().String()
+	}
+
+	// 用户名和密码和下载时一样从 SystemContext 或 docker login 的配置中读取, 读取失败时匿名访问
+	var auth types.DockerAuthConfig
+	if creds, err := config.GetCredentialsForRef(sysCtx, named); err == nil {
+		auth = creds
+	}
+
+	client, insecure, err := newRegistryHTTPClient(sysCtx, named, host)
+	if err != nil {
+		return unknownRateLimit(), err
+	}
+	c := &rateLimitChecker{client: client, auth: auth}
+	if sysCtx != nil {
+		c.bearerToken = sysCtx.DockerBearerRegistryToken
+	}
+
+	limit, err := c.check(ctx, "https://"+host, reference.Path(named), manifestRef)
+	// 和 containers/image 一样, insecure registry 的 https 连接失败时改用 http
+	var urlErr *url.Error
+	if err != nil && insecure && errors.As(err, &urlErr) {
+		return c.check(ctx, "http://"+host, reference.Path(named), manifestRef)
+	}
+	return limit, err
+}
+
+// newRegistryHTTPClient 按 SystemContext 配置代理和 TLS: 证书目录 (DockerCertPath 或 certs.d/<host>) 和跳过证书校验;
+// insecure 为 true 时 registry 可以使用 http
+func newRegistryHTTPClient(sysCtx *types.SystemContext, named reference.Named, host string) (*http.Client, bool, error) {
+	client := newHTTPClient(sysCtx)
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if err := tlsclientconfig.SetupCertificates(registryCertDir(sysCtx, host), transport.TLSClientConfig); err != nil {
+		return nil, false, err
+	}
+
+	insecure := false
+	if sysCtx != nil && sysCtx.DockerInsecureSkipTLSVerify != types.OptionalBoolUndefined {
+		insecure = sysCtx.DockerInsecureSkipTLSVerify == types.OptionalBoolTrue
+	} else if reg, err := sysregistriesv2.FindRegistry(sysCtx, named.Name()); err == nil && reg != nil {
+		insecure = reg.Insecure
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = insecure
+	return client, insecure, nil
+}
+
+// registryCertDir 和 containers/image 查找证书目录的顺序相同
+func registryCertDir(sysCtx *types.SystemContext, host string) string {
+	if sysCtx != nil && sysCtx.DockerCertPath != "" {
+		return sysCtx.DockerCertPath
+	}
+	if sysCtx != nil && sysCtx.DockerPerHostCertDirPath != "" {
+		return filepath.Join(sysCtx.DockerPerHostCertDirPath, host)
+	}
+	dirs := []string{"/etc/containers/certs.d", "/etc/docker/certs.d"}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append([]string{filepath.Join(home, ".config", "containers", "certs.d")}, dirs...)
+	}
+	for _, dir := range dirs {
+		if p := filepath.Join(dir, host); FileExists(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func newHTTPClient(sysCtx *types.SystemContext) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if sysCtx != nil && sysCtx.DockerProxyURL != nil {
+		transport.Proxy = http.ProxyURL(sysCtx.DockerProxyURL)
+	}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
+
+type rateLimitChecker struct {
+	client *http.Client
+	auth   types.DockerAuthConfig
+	// bearerToken SystemContext 中直接指定的 token, 不需要再获取
+	bearerToken string
+}
+
+func (c *rateLimitChecker) check(ctx context.Context, baseURL, repo, manifestRef string) (RateLimit, error) {
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", baseURL, repo, manifestRef)
+
+	res, err := c.head(ctx, manifestURL, c.bearerToken)
+	if err != nil {
+		return unknownRateLimit(), err
+	}
+
+	// 需要鉴权时按 WWW-Authenticate 获取 token 后重试
+	if res.StatusCode == http.StatusUnauthorized {
+		token, err := c.token(ctx, res.Header.Get("WWW-Authenticate"), repo)
+		if err != nil {
+			return unknownRateLimit(), err
+		}
+		res, err = c.head(ctx, manifestURL, token)
+		if err != nil {
+			return unknownRateLimit(), err
+		}
+	}
+
+	limit := parseRateLimit(res.Header)
+	switch res.StatusCode {
+	case http.StatusOK:
+		return limit, nil
+	case http.StatusTooManyRequests:
+		return limit, &RateLimitError{Limit: limit, Err: docker.ErrTooManyRequests}
+	default:
+		return limit, docker.UnexpectedHTTPStatusError{StatusCode: res.StatusCode}
+	}
+}
+
+func (c *rateLimitChecker) head(ctx context.Context, u, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.oci.image.manifest.v1+json",
+	}, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.auth.Username != "" {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// token 按 Bearer realm="...",service="...",scope="..." 获取 token
+func (c *rateLimitChecker) token(ctx context.Context, challenge, repo string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return "", fmt.Errorf("unsupported auth challenge: %q", challenge)
+	}
+
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repo + ":pull"
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.auth.Username != "" {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", docker.UnexpectedHTTPStatusError{StatusCode: res.StatusCode}
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// parseChallenge 解析 WWW-Authenticate, 如 Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return scheme, params
+}
+
+func unknownRateLimit() RateLimit {
+	return RateLimit{Limit: -1, Remaining: -1}
+}
+
+// parseRateLimit 解析 ratelimit-limit: 100;w=21600 和 ratelimit-remaining: 76;w=21600
+func parseRateLimit(h http.Header) RateLimit {
+	limit := unknownRateLimit()
+
+	parse := func(v string) (int, time.Duration, bool) {
+		value, params, _ := strings.Cut(v, ";")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, 0, false
+		}
+		var window time.Duration
+		for _, p := range strings.Split(params, ";") {
+			if w, ok := strings.CutPrefix(strings.TrimSpace(p), "w="); ok {
+				if sec, err := strconv.Atoi(w); err == nil {
+					window = time.Duration(sec) * time.Second
+				}
+			}
+		}
+		return n, window, true
+	}
+
+	if n, w, ok := parse(h.Get("Ratelimit-Limit")); ok {
+		limit.Limit, limit.Window = n, w
+	}
+	if n, w, ok := parse(h.Get("Ratelimit-Remaining")); ok {
+		limit.Remaining = n
+		if limit.Window == 0 {
+			limit.Window = w
+		}
+	}
+
+	if v := h.Get("Retry-After"); v != "" {
+		if sec, err := strconv.Atoi(v); err == nil {
+			limit.RetryAfter = time.Duration(sec) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			limit.RetryAfter = time.Until(t).Round(time.Second)
+		}
+	}
+	return limit
+}
+
+// rateLimitPollInterval 被限流且 registry 没有返回 Retry-After 时, 重试的间隔
+var rateLimitPollInterval = time.Minute
+
+// rateLimitWaiter 被限流时在 deadline 之前等待后重试, 一次 Pull 中的所有请求共用 deadline
+type rateLimitWaiter struct {
+	ctx      context.Context
+	image    string
+	sysCtx   *types.SystemContext
+	log      Logger
+	deadline time.Time
+}
+
+// wait err 是限流错误并且等待后不会超过 deadline 时, 等待后返回 nil 表示可以重试; 否则返回需要返回给调用者的错误
+//
+// containers/image 内部已经按 Retry-After 重试过几次, 这里返回的 429 可能是 distribution 的 errcode 或 docker.ErrTooManyRequests
+func (w *rateLimitWaiter) wait(err error) error {
+	if w == nil || Classify(err) != ClassRateLimited {
+		return err
+	}
+
+	// 429 的响应里没有限流信息, 再用 HEAD 查询一次
+	limit, _ := CheckRateLimit(w.ctx, w.image, w.sysCtx)
+	wait := limit.RetryAfter
+	if wait <= 0 {
+		wait = rateLimitPollInterval
+	}
+	if time.Now().Add(wait).After(w.deadline) {
+		return &RateLimitError{Limit: limit, Err: err}
+	}
+
+	w.log.Warnf("Rate limited by registry (%s pulls remaining), retrying in %s", limit, wait)
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
@@ -0,0 +1,193 @@
+package dockerpull
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/types"
+)
+
+// newFakeRegistry 模拟 Docker Hub: manifest 需要 bearer token, 响应中带有限流信息
+func newFakeRegistry(t *testing.T, remaining int) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "repository:library/nginx:pull" {
+			t.Errorf("token scope = %q", r.URL.Query().Get("scope"))
+		}
+		fmt.Fprint(w, `{"token":"secret"}`)
+	})
+	mux.HandleFunc("/v2/library/nginx/manifests/1.25", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("method = %s, want HEAD", r.Method)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.docker.io",scope="repository:library/nginx:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Ratelimit-Limit", "100;w=21600")
+		w.Header().Set("Ratelimit-Remaining", fmt.Sprintf("%d;w=21600", remaining))
+		if remaining == 0 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckRateLimit(t *testing.T) {
+	srv := newFakeRegistry(t, 76)
+
+	c := &rateLimitChecker{client: srv.Client()}
+	limit, err := c.check(context.Background(), srv.URL, "library/nginx", "1.25")
+	if err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+
+	want := RateLimit{Limit: 100, Remaining: 76, Window: 6 * time.Hour}
+	if limit != want {
+		t.Errorf("check() = %+v, want %+v", limit, want)
+	}
+	if limit.String() != "76/100 per 6h0m0s" {
+		t.Errorf("String() = %q", limit.String())
+	}
+}
+
+func TestCheckRateLimitExceeded(t *testing.T) {
+	srv := newFakeRegistry(t, 0)
+
+	c := &rateLimitChecker{client: srv.Client()}
+	limit, err := c.check(context.Background(), srv.URL, "library/nginx", "1.25")
+
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) {
+		t.Fatalf("check() error = %v, want *RateLimitError", err)
+	}
+	if !errors.Is(err, docker.ErrTooManyRequests) || Classify(err) != ClassRateLimited {
+		t.Errorf("Classify() = %v, want %v", Classify(err), ClassRateLimited)
+	}
+	if limit.Remaining != 0 || limit.RetryAfter != 2*time.Minute {
+		t.Errorf("check() = %+v, want 0 remaining and retry after 2m", limit)
+	}
+}
+
+func TestParseRateLimitMissingHeaders(t *testing.T) {
+	limit := parseRateLimit(http.Header{})
+	if limit.Known() {
+		t.Errorf("parseRateLimit() = %+v, want unknown", limit)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`)
+	if scheme != "Bearer" {
+		t.Errorf("scheme = %q", scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/nginx:pull",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("params[%s] = %q, want %q", k, params[k], v)
+		}
+	}
+}
+
+// newRateLimitedRegistry 从 cache 提供镜像, 前 limited 次 GET tag 的 manifest 返回 429
+func newRateLimitedRegistry(t *testing.T, cacheDir string) (srv *httptest.Server, limit func(n int), heads func() int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	limited, headCount := 0, 0
+	registry := NewRegistryHandler(cacheDir, nil)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/manifests/1.25") {
+			mu.Lock()
+			if r.Method == http.MethodHead {
+				headCount++
+			}
+			rateLimited := r.Method == http.MethodGet && limited > 0
+			if rateLimited {
+				limited--
+			}
+			mu.Unlock()
+
+			if rateLimited {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"errors":[{"code":"TOOMANYREQUESTS","message":"You have reached your pull rate limit"}]}`)
+				return
+			}
+		}
+		registry.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	limit = func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		limited = n
+	}
+	heads = func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return headCount
+	}
+	return srv, limit, heads
+}
+
+func TestPullRateLimitWait(t *testing.T) {
+	registryCache := t.TempDir()
+	populateCache(t, registryCache, "nginx:1.25")
+	srv, limit, heads := newRateLimitedRegistry(t, registryCache)
+	image := strings.TrimPrefix(srv.URL, "http://") + "/library/nginx:1.25"
+
+	interval := rateLimitPollInterval
+	rateLimitPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { rateLimitPollInterval = interval })
+
+	t.Chdir(t.TempDir())
+	pull := func(wait time.Duration) error {
+		_, err := Pull(context.Background(), Options{
+			Image:         image,
+			Arch:          "arm64",
+			CacheDir:      t.TempDir(),
+			RateLimitWait: wait,
+			SystemContext: &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue},
+		})
+		return err
+	}
+
+	// containers/image 内部会重试 5 次, 之后 429 才会返回给 Pull
+	limit(5)
+	if err := pull(time.Minute); err != nil {
+		t.Fatalf("Pull() error = %v, want success after waiting", err)
+	}
+	// 等待前用 HEAD 查询限流信息, insecure registry 使用 http
+	if heads() == 0 {
+		t.Error("CheckRateLimit was not called before retrying")
+	}
+
+	limit(5)
+	err := pull(0)
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) || Classify(err) != ClassRateLimited {
+		t.Errorf("Pull() without wait error = %v, want *RateLimitError", err)
+	}
+}
@@ -0,0 +1,38 @@
+package dockerpull
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// RunReport 一次运行的结果报告, 下载多个 tag 时包含多个镜像
+type RunReport struct {
+	Version    string    `json:"version"`
+	Images     []Result  `json:"images"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// WriteReport 把报告写入文件, path 为 - 时输出到 stdout
+func WriteReport(path string, report RunReport) error {
+	return writeReport(path, os.Stdout, report)
+}
+
+func writeReport(path string, stdout io.Writer, report RunReport) error {
+	if path == "-" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	defer f.Close()
+
+	return WriteJsonFile(f, report)
+}
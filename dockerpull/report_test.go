@@ -0,0 +1,146 @@
+package dockerpull
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkReportedResult 检查元数据或报告中的一个镜像包含 tar 的全部信息
+func checkReportedResult(t *testing.T, name string, got map[string]any, output string) {
+	t.Helper()
+
+	sum, err := FileSHA256(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"reference": "docker.io/library/nginx:1.25",
+		"arch":      "arm64",
+		"platform":  "linux/arm64",
+		"archive":   filepath.Base(output),
+		"sha256":    sum,
+		"size":      float64(fi.Size()),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: %s = %v, want %v", name, k, got[k], v)
+		}
+	}
+	for _, k := range []string{"manifestDigest", "platformDigest"} {
+		if s, _ := got[k].(string); !strings.HasPrefix(s, "sha256:") {
+			t.Errorf("%s: %s = %v, want a digest", name, k, got[k])
+		}
+	}
+	if s, _ := got["configDigest"].(string); len(s) != 64 {
+		t.Errorf("%s: configDigest = %v", name, got["configDigest"])
+	}
+
+	layers, _ := got["layers"].([]any)
+	if len(layers) != 1 {
+		t.Fatalf("%s: layers = %v, want 1 layer", name, got["layers"])
+	}
+	layer, _ := layers[0].(map[string]any)
+	if s, _ := layer["digest"].(string); !strings.HasPrefix(s, "sha256:") || layer["size"] != float64(len("layer")) {
+		t.Errorf("%s: layer = %v", name, layer)
+	}
+}
+
+func TestArchiveMetaAndReport(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	populateCache(t, cacheDir, "nginx:1.25")
+
+	result, err := Pull(context.Background(), Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: cacheDir, Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tar 旁边的元数据
+	data, err := os.ReadFile(archiveMetaPath(result.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta map[string]any
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	checkReportedResult(t, "sidecar", meta, result.Output)
+
+	report := RunReport{Version: "test", Images: []Result{result}, StartedAt: result.StartedAt}
+	decodeImage := func(data []byte) map[string]any {
+		t.Helper()
+		var got struct {
+			Version string           `json:"version"`
+			Images  []map[string]any `json:"images"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != "test" || len(got.Images) != 1 {
+			t.Fatalf("report = %s", data)
+		}
+		if got.Images[0]["output"] != result.Output {
+			t.Errorf("output = %v, want %s", got.Images[0]["output"], result.Output)
+		}
+		return got.Images[0]
+	}
+
+	// -report report.json
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := writeReport(reportPath, nil, report); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReportedResult(t, "report file", decodeImage(data), result.Output)
+
+	// -report - 输出到 stdout
+	var stdout bytes.Buffer
+	if err := writeReport("-", &stdout, report); err != nil {
+		t.Fatal(err)
+	}
+	checkReportedResult(t, "report stdout", decodeImage(stdout.Bytes()), result.Output)
+}
+
+func TestPullSkipExistingReport(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Chdir(t.TempDir())
+	populateCache(t, cacheDir, "nginx:1.25")
+
+	opts := Options{Image: "nginx:1.25", Arch: "arm64", CacheDir: cacheDir, Offline: true}
+	first, err := Pull(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// manifest digest 变化但 config 相同 (如 manifest list 增加了平台) 时, 通过 SkipExisting 跳过
+	meta := first.ArchiveMeta
+	meta.ManifestDigest = "sha256:other"
+	if err := writeArchiveMeta(first.Output, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.SkipExisting = true
+	result, err := Pull(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Skipped {
+		t.Fatal("Pull() with SkipExisting was not skipped")
+	}
+	if result.Sha256 != first.Sha256 || result.Size != first.Size || len(result.Layers) != 1 {
+		t.Errorf("skipped result = %+v, want sha256, size and layers of the existing tar", result.ArchiveMeta)
+	}
+}
@@ -0,0 +1,215 @@
+package dockerpull
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SemVer 镜像 tag 中的语义化版本, 允许省略 minor 和 patch (如 1, 1.25) 以及 v 前缀
+type SemVer struct {
+	Major int
+	Minor int
+	Patch int
+	// Pre - 之后的部分, 如 1.25.3-alpine 中的 alpine
+	Pre string
+	// Parts 实际写出的数字段数, 1.25 为 2
+	Parts int
+}
+
+// ParseSemVer 解析 tag 为语义化版本, 不是版本号格式时返回 false
+func ParseSemVer(tag string) (SemVer, bool) {
+	s := strings.TrimPrefix(tag, "v")
+
+	// 忽略 build metadata
+	s, _, _ = strings.Cut(s, "+")
+
+	var v SemVer
+	s, v.Pre, _ = strings.Cut(s, "-")
+
+	nums := strings.Split(s, ".")
+	if len(nums) == 0 || len(nums) > 3 {
+		return SemVer{}, false
+	}
+
+	for i, n := range nums {
+		if n == "" {
+			return SemVer{}, false
+		}
+		x, err := strconv.Atoi(n)
+		if err != nil || x < 0 {
+			return SemVer{}, false
+		}
+		switch i {
+		case 0:
+			v.Major = x
+		case 1:
+			v.Minor = x
+		case 2:
+			v.Patch = x
+		}
+	}
+	v.Parts = len(nums)
+
+	return v, true
+}
+
+// Compare 比较两个版本, 返回 -1, 0, 1; 带 Pre 的版本小于同号的正式版本
+func (v SemVer) Compare(o SemVer) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	case v.Pre < o.Pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// SortTagsSemVer 按语义化版本从小到大排序, 不是版本号的 tag 按字母顺序排在最后
+func SortTagsSemVer(tags []string) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		vi, oki := ParseSemVer(tags[i])
+		vj, okj := ParseSemVer(tags[j])
+
+		switch {
+		case oki && okj:
+			if c := vi.Compare(vj); c != 0 {
+				return c < 0
+			}
+			// 1.25 和 1.25.0 版本相同时, 写得短的在前
+			if vi.Parts != vj.Parts {
+				return vi.Parts < vj.Parts
+			}
+			return tags[i] < tags[j]
+		case oki:
+			return true
+		case okj:
+			return false
+		default:
+			return tags[i] < tags[j]
+		}
+	})
+}
+
+// SemVerRange 语义化版本范围, 多个条件之间是且的关系
+type SemVerRange struct {
+	constraints []semVerConstraint
+}
+
+type semVerConstraint struct {
+	op string
+	v  SemVer
+}
+
+// ParseSemVerRange 解析版本范围, 支持
+//
+//	~1.25       >=1.25.0 <1.26.0
+//	^1.2        >=1.2.0 <2.0.0
+//	1.25.*      >=1.25.0 <1.26.0
+//	>=1.2 <1.5  多个比较条件用空格分隔
+func ParseSemVerRange(expr string) (SemVerRange, bool) {
+	var r SemVerRange
+
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return r, false
+	}
+
+	for _, f := range fields {
+		switch {
+		case strings.HasPrefix(f, "~"):
+			v, ok := ParseSemVer(f[1:])
+			if !ok {
+				return r, false
+			}
+			upper := SemVer{Major: v.Major + 1}
+			if v.Parts > 1 {
+				upper = SemVer{Major: v.Major, Minor: v.Minor + 1}
+			}
+			r.constraints = append(r.constraints, semVerConstraint{">=", v}, semVerConstraint{"<", upper})
+
+		case strings.HasPrefix(f, "^"):
+			v, ok := ParseSemVer(f[1:])
+			if !ok {
+				return r, false
+			}
+			// 0.x 版本中 minor 就是不兼容的变更
+			upper := SemVer{Major: v.Major + 1}
+			if v.Major == 0 && v.Parts > 1 {
+				upper = SemVer{Minor: v.Minor + 1}
+			}
+			r.constraints = append(r.constraints, semVerConstraint{">=", v}, semVerConstraint{"<", upper})
+
+		case strings.HasSuffix(f, ".*"):
+			v, ok := ParseSemVer(strings.TrimSuffix(f, ".*"))
+			if !ok || v.Parts > 2 {
+				return r, false
+			}
+			upper := SemVer{Major: v.Major + 1}
+			if v.Parts == 2 {
+				upper = SemVer{Major: v.Major, Minor: v.Minor + 1}
+			}
+			r.constraints = append(r.constraints, semVerConstraint{">=", v}, semVerConstraint{"<", upper})
+
+		default:
+			op := ""
+			for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+				if strings.HasPrefix(f, prefix) {
+					op = prefix
+					break
+				}
+			}
+			if op == "" {
+				return r, false
+			}
+			v, ok := ParseSemVer(f[len(op):])
+			if !ok {
+				return r, false
+			}
+			r.constraints = append(r.constraints, semVerConstraint{op, v})
+		}
+	}
+
+	return r, true
+}
+
+// Match 判断 v 是否在范围内, 带 Pre 的版本 (如 1.25.3-alpine) 不匹配
+func (r SemVerRange) Match(v SemVer) bool {
+	if v.Pre != "" {
+		return false
+	}
+	for _, c := range r.constraints {
+		cmp := v.Compare(c.v)
+		var ok bool
+		switch c.op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package dockerpull
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		input string
+		want  SemVer
+		ok    bool
+	}{
+		{input: "1.25.3", want: SemVer{Major: 1, Minor: 25, Patch: 3, Parts: 3}, ok: true},
+		{input: "v2", want: SemVer{Major: 2, Parts: 1}, ok: true},
+		{input: "1.25-alpine", want: SemVer{Major: 1, Minor: 25, Pre: "alpine", Parts: 2}, ok: true},
+		{input: "latest", ok: false},
+		{input: "1.2.3.4", ok: false},
+		{input: "1..2", ok: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseSemVer(tt.input)
+		if ok != tt.ok {
+			t.Errorf("ParseSemVer(%q) ok = %v, want %v", tt.input, ok, tt.ok)
+			continue
+		}
+		if ok && got != tt.want {
+			t.Errorf("ParseSemVer(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSortTagsSemVer(t *testing.T) {
+	tags := []string{"latest", "1.10.0", "1.9", "1.9.1", "alpine", "1.10.0-alpine", "1.2"}
+	SortTagsSemVer(tags)
+
+	want := []string{"1.2", "1.9", "1.9.1", "1.10.0-alpine", "1.10.0", "alpine", "latest"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("SortTagsSemVer() = %v, want %v", tags, want)
+	}
+}
+
+func TestSemVerRange(t *testing.T) {
+	tests := []struct {
+		expr string
+		tag  string
+		want bool
+	}{
+		{expr: "~1.25", tag: "1.25.3", want: true},
+		{expr: "~1.25", tag: "1.25", want: true},
+		{expr: "~1.25", tag: "1.26.0", want: false},
+		{expr: "~1.25", tag: "1.25.3-alpine", want: false},
+		{expr: "^1.2", tag: "1.9.0", want: true},
+		{expr: "^1.2", tag: "2.0.0", want: false},
+		{expr: "^0.3", tag: "0.4.0", want: false},
+		{expr: "1.25.*", tag: "1.25.10", want: true},
+		{expr: ">=1.2 <1.5", tag: "1.4.9", want: true},
+		{expr: ">=1.2 <1.5", tag: "1.5.0", want: false},
+	}
+
+	for _, tt := range tests {
+		r, ok := ParseSemVerRange(tt.expr)
+		if !ok {
+			t.Errorf("ParseSemVerRange(%q) failed", tt.expr)
+			continue
+		}
+		v, _ := ParseSemVer(tt.tag)
+		if got := r.Match(v); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.expr, tt.tag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package dockerpull
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	anchoredNameRegexp = regexp.MustCompile(`^` + reference.NameRegexp.String() + `$`)
+	anchoredTagRegexp  = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+)
+
+// defaultDomain 仓库名称不带域名时优先查找的 registry
+const defaultDomain = "docker.io"
+
+// NewRegistryHandler 把 cache 作为只读的 OCI distribution registry, 提供 /v2/ 下的 manifest, blob 和 tag 列表接口
+//
+// 仓库名称可以带源 registry 的域名, 如 quay.io/prometheus/prometheus; 不带域名时在 cache 中所有 registry 下查找, 优先 docker.io,
+// 所以 docker pull <地址>/nginx:1.25 和 docker pull <地址>/library/nginx:1.25 都可以
+func NewRegistryHandler(cacheDir string, logger Logger) http.Handler {
+	return &registryHandler{cacheDir: cacheDir, log: loggerOrNop(logger)}
+}
+
+type registryHandler struct {
+	cacheDir string
+	log      Logger
+	// upstream 不为空时为 pull-through 代理模式, cache 中没有的内容从上游 registry 获取
+	upstream *upstream
+}
+
+func (h *registryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.serveError(w, r, errcode.ErrorCodeUnsupported.WithMessage("the registry is read-only"))
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/v2/")
+	switch {
+	case !ok:
+		h.serveError(w, r, errcode.ErrorCodeUnsupported)
+	case path == "":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	case strings.HasSuffix(path, "/tags/list"):
+		h.serveTags(w, r, strings.TrimSuffix(path, "/tags/list"))
+	default:
+		if name, ref, ok := cutLast(path, "/manifests/"); ok {
+			h.serveManifest(w, r, name, ref)
+		} else if name, ref, ok := cutLast(path, "/blobs/"); ok {
+			h.serveBlob(w, r, name, ref)
+		} else {
+			h.serveError(w, r, errcode.ErrorCodeUnsupported)
+		}
+	}
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+func (h *registryHandler) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	h.log.Warnf("%s %s: %v", r.Method, r.URL.Path, err)
+	_ = errcode.ServeJSON(w, err)
+}
+
+func (h *registryHandler) serveManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	if !anchoredNameRegexp.MatchString(name) {
+		h.serveError(w, r, v2.ErrorCodeNameInvalid.WithDetail(name))
+		return
+	}
+
+	d, err := digest.Parse(ref)
+	if err != nil {
+		if !anchoredTagRegexp.MatchString(ref) {
+			h.serveError(w, r, v2.ErrorCodeTagInvalid.WithDetail(ref))
+			return
+		}
+		if h.upstream != nil {
+			if d, err = h.upstream.resolveTag(r.Context(), name, ref); err != nil {
+				h.serveError(w, r, upstreamError(err, v2.ErrorCodeManifestUnknown.WithDetail(name+":"+ref)))
+				return
+			}
+		} else {
+			var ok bool
+			if d, ok = h.resolveTag(name, ref); !ok {
+				h.serveError(w, r, v2.ErrorCodeManifestUnknown.WithDetail(name+":"+ref))
+				return
+			}
+		}
+	}
+
+	raw, err := os.ReadFile(manifestPath(h.cacheDir, d))
+	if err != nil && h.upstream != nil {
+		raw, err = h.upstream.fetchManifest(r.Context(), name, d)
+		if err != nil {
+			h.serveError(w, r, upstreamError(err, v2.ErrorCodeManifestUnknown.WithDetail(d.String())))
+			return
+		}
+	}
+	if err != nil {
+		h.serveError(w, r, v2.ErrorCodeManifestUnknown.WithDetail(d.String()))
+		return
+	}
+	if d.Algorithm().FromBytes(raw) != d {
+		h.serveError(w, r, v2.ErrorCodeManifestInvalid.WithMessage("cached manifest is corrupt").WithDetail(d.String()))
+		return
+	}
+
+	mimeType := manifest.GuessMIMEType(raw)
+	if h.upstream != nil {
+		h.upstream.rememberManifest(raw, mimeType, d)
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Etag", `"`+d.String()+`"`)
+	if r.Method == http.MethodGet {
+		w.Write(raw)
+	}
+}
+
+func (h *registryHandler) serveBlob(w http.ResponseWriter, r *http.Request, name, ref string) {
+	if !anchoredNameRegexp.MatchString(name) {
+		h.serveError(w, r, v2.ErrorCodeNameInvalid.WithDetail(name))
+		return
+	}
+	d, err := digest.Parse(ref)
+	if err != nil {
+		h.serveError(w, r, v2.ErrorCodeDigestInvalid.WithDetail(ref))
+		return
+	}
+
+	if h.serveCachedBlob(w, r, d) {
+		return
+	}
+	if h.upstream != nil {
+		h.upstream.serveBlob(w, r, name, d, h)
+		return
+	}
+	h.serveError(w, r, v2.ErrorCodeBlobUnknown.WithDetail(d.String()))
+}
+
+// serveCachedBlob blob 在 cache 中时返回 blob 并返回 true
+func (h *registryHandler) serveCachedBlob(w http.ResponseWriter, r *http.Request, d digest.Digest) bool {
+	// blob 按 digest 保存, 和仓库无关
+	for _, p := range []string{layerPath(h.cacheDir, d.Encoded()), configPath(h.cacheDir, d.Encoded())} {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			continue
+		}
+		touchBlob(p)
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.Header().Set("Etag", `"`+d.String()+`"`)
+		// ServeContent 处理 HEAD, Range 和 If-None-Match
+		http.ServeContent(w, r, "", fi.ModTime(), f)
+		return true
+	}
+	return false
+}
+
+func (h *registryHandler) serveTags(w http.ResponseWriter, r *http.Request, name string) {
+	if !anchoredNameRegexp.MatchString(name) {
+		h.serveError(w, r, v2.ErrorCodeNameInvalid.WithDetail(name))
+		return
+	}
+
+	seen := make(map[string]bool)
+	tags := []string{}
+	if h.upstream != nil {
+		// 上游不可用时使用 cache 中的 tag
+		upstreamTags, err := h.upstream.listTags(r.Context(), name)
+		if err != nil {
+			h.log.Warnf("Failed to list tags of %s upstream, using cached tags: %v", name, err)
+		}
+		for _, tag := range upstreamTags {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	for _, dir := range h.repoDirs(name) {
+		entries, _ := os.ReadDir(filepath.Join(dir, "_tags"))
+		for _, e := range entries {
+			if !e.IsDir() && anchoredTagRegexp.MatchString(e.Name()) && !seen[e.Name()] {
+				seen[e.Name()] = true
+				tags = append(tags, e.Name())
+			}
+		}
+	}
+	if len(tags) == 0 {
+		h.serveError(w, r, v2.ErrorCodeNameUnknown.WithDetail(name))
+		return
+	}
+	sort.Strings(tags)
+
+	// 分页: n 为每页数量, last 为上一页的最后一个 tag
+	if last := r.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n >= 0 && n < len(tags) {
+		tags = tags[:n]
+		if n > 0 {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=%d&last=%s>; rel="next"`, name, n, tags[n-1]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{name, tags})
+}
+
+// resolveTag 在 cache 中查找 tag 指向的 manifest digest
+func (h *registryHandler) resolveTag(name, tag string) (digest.Digest, bool) {
+	for _, dir := range h.repoDirs(name) {
+		raw, err := os.ReadFile(filepath.Join(dir, "_tags", tag))
+		if err != nil {
+			continue
+		}
+		if d, err := digest.Parse(strings.TrimSpace(string(raw))); err == nil {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+// repoDirs 仓库名称在 cache 中可能对应的目录 refs/<domain>/<path>, 按优先级排序
+func (h *registryHandler) repoDirs(name string) []string {
+	refsDir := filepath.Join(h.cacheDir, "refs")
+
+	// 第一段是域名时只查找这个 registry
+	if domain, path, ok := strings.Cut(name, "/"); ok && hasDomain(name) {
+		return []string{filepath.Join(refsDir, strings.ReplaceAll(domain, ":", "_"), filepath.FromSlash(path))}
+	}
+
+	entries, _ := os.ReadDir(refsDir)
+	var domains []string
+	for _, e := range entries {
+		if e.IsDir() {
+			domains = append(domains, e.Name())
+		}
+	}
+	sort.SliceStable(domains, func(i, j int) bool {
+		return domains[i] == defaultDomain && domains[j] != defaultDomain
+	})
+
+	var dirs []string
+	for _, domain := range domains {
+		dirs = append(dirs, filepath.Join(refsDir, domain, filepath.FromSlash(name)))
+		// Docker Hub 的官方镜像, nginx 即 library/nginx
+		if domain == defaultDomain && !strings.Contains(name, "/") {
+			dirs = append(dirs, filepath.Join(refsDir, domain, "library", name))
+		}
+	}
+	return dirs
+}
+
+// hasDomain 仓库名称的第一段是否是域名, 同 reference 的规则
+func hasDomain(name string) bool {
+	domain, _, ok := strings.Cut(name, "/")
+	return ok && (strings.ContainsAny(domain, ".:") || domain == "localhost")
+}
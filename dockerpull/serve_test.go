@@ -0,0 +1,113 @@
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+)
+
+func TestRegistryHandlerPull(t *testing.T) {
+	cacheDir := t.TempDir()
+	populateCache(t, cacheDir, "nginx:1.25")
+
+	srv := httptest.NewServer(NewRegistryHandler(cacheDir, nil))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	// 用下载本身作为客户端, 从 serve 的 cache 下载到另一个 cache
+	t.Chdir(t.TempDir())
+	for _, image := range []string{host + "/library/nginx:1.25", host + "/nginx:1.25", host + "/docker.io/library/nginx:1.25"} {
+		result, err := Pull(context.Background(), Options{
+			Image:         image,
+			Arch:          "arm64",
+			CacheDir:      t.TempDir(),
+			SystemContext: &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue},
+		})
+		if err != nil {
+			t.Fatalf("Pull(%s) error = %v", image, err)
+		}
+		if result.Downloads != 2 {
+			t.Errorf("Pull(%s) Downloads = %d, want 2", image, result.Downloads)
+		}
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	cacheDir := t.TempDir()
+	layer := populateCache(t, cacheDir, "nginx:1.25")
+
+	srv := httptest.NewServer(NewRegistryHandler(cacheDir, nil))
+	t.Cleanup(srv.Close)
+
+	do := func(method, path string, header map[string]string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	blobPath := "/v2/library/nginx/blobs/" + layer.String()
+
+	t.Run("range", func(t *testing.T) {
+		resp, body := do(http.MethodGet, blobPath, map[string]string{"Range": "bytes=1-3"})
+		if resp.StatusCode != http.StatusPartialContent || body != "aye" {
+			t.Errorf("status = %d, body = %q, want 206 aye", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		resp, body := do(http.MethodHead, blobPath, nil)
+		if resp.StatusCode != http.StatusOK || resp.ContentLength != 5 || body != "" {
+			t.Errorf("status = %d, length = %d, body = %q", resp.StatusCode, resp.ContentLength, body)
+		}
+		if resp.Header.Get("Docker-Content-Digest") != layer.String() {
+			t.Errorf("Docker-Content-Digest = %q", resp.Header.Get("Docker-Content-Digest"))
+		}
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		resp, body := do(http.MethodGet, "/v2/nginx/tags/list", nil)
+		var list struct {
+			Name string   `json:"name"`
+			Tags []string `json:"tags"`
+		}
+		json.Unmarshal([]byte(body), &list)
+		if resp.StatusCode != http.StatusOK || len(list.Tags) != 1 || list.Tags[0] != "1.25" {
+			t.Errorf("status = %d, body = %s", resp.StatusCode, body)
+		}
+	})
+
+	errorTests := []struct {
+		name, method, path string
+		status             int
+		code               string
+	}{
+		{"unknown tag", http.MethodGet, "/v2/library/nginx/manifests/1.26", http.StatusNotFound, "MANIFEST_UNKNOWN"},
+		{"unknown blob", http.MethodGet, "/v2/library/nginx/blobs/sha256:" + strings.Repeat("0", 64), http.StatusNotFound, "BLOB_UNKNOWN"},
+		{"unknown repository", http.MethodGet, "/v2/library/redis/tags/list", http.StatusNotFound, "NAME_UNKNOWN"},
+		{"invalid name", http.MethodGet, "/v2/../../etc/manifests/latest", http.StatusBadRequest, "NAME_INVALID"},
+		{"read-only", http.MethodPut, "/v2/library/nginx/manifests/1.25", http.StatusMethodNotAllowed, "UNSUPPORTED"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(tt.method, tt.path, nil)
+			if resp.StatusCode != tt.status || !strings.Contains(body, tt.code) {
+				t.Errorf("status = %d, body = %s, want %d %s", resp.StatusCode, body, tt.status, tt.code)
+			}
+		})
+	}
+}
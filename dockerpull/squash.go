@@ -0,0 +1,150 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Squash 把所有 layer (处理 whiteout 之后) 合并为一个新的 layer, 并生成只有一个 diff_id 的 config
+//
+// 新的 layer 和 config 写入 cache, 之后 BuildTar 会使用它们代替原来的 LayersDigest
+func (t *TarInfo) Squash() error {
+
+	var layerPaths []string
+	for _, layerDigest := range t.LayersDigest {
+		layerPaths = append(layerPaths, layerPath(t.cacheDir(), layerDigest))
+	}
+
+	diffID, err := buildSquashedLayer(t.cacheDir(), layerPaths)
+	if err != nil {
+		return fmt.Errorf("failed to squash layers: %w", err)
+	}
+
+	configDigest, err := buildSquashedConfig(t.cacheDir(), t.ConfigDigest, diffID, len(t.LayersDigest))
+	if err != nil {
+		return fmt.Errorf("failed to build squashed config: %w", err)
+	}
+
+	t.logger().Infof("%s", color.HiCyanString("Squashed %d layers into %s", len(t.LayersDigest), diffID[:16]))
+
+	fi, err := os.Stat(layerPath(t.cacheDir(), diffID))
+	if err != nil {
+		return fmt.Errorf("failed to stat squashed layer: %w", err)
+	}
+
+	t.SourceConfigDigest = t.ConfigDigest
+	t.ArchiveLayers = []LayerReport{{
+		Digest:    "sha256:" + diffID,
+		Size:      fi.Size(),
+		MediaType: ocispec.MediaTypeImageLayer,
+	}}
+	t.ConfigDigest = configDigest
+	t.LayersDigest = []string{diffID}
+	t.Squashed = true
+	return nil
+}
+
+// buildSquashedLayer 合并所有 layer, 写入未压缩的 <cacheDir>/layers/<diff_id>/layer.tar, 返回 diff_id
+func buildSquashedLayer(cacheDir string, layerPaths []string) (string, error) {
+	layersDir := filepath.Join(cacheDir, "layers")
+	if err := os.MkdirAll(layersDir, 0755); err != nil {
+		return "", fmt.Errorf("create folder failed: %v", err)
+	}
+
+	// 先写临时文件, 计算出 diff_id 之后再移动到对应目录
+	tmpFile, err := os.CreateTemp(layersDir, "squash-*.tar")
+	if err != nil {
+		return "", fmt.Errorf("failed to create squashed layer: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(tmpFile, hash))
+
+	err = mergeLayers(layerPaths, func(hdr *tar.Header, r io.Reader) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tw, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to close squashed layer: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to close squashed layer: %v", err)
+	}
+
+	diffID := hex.EncodeToString(hash.Sum(nil))
+
+	blobPath := filepath.Join(layersDir, diffID)
+	if err := os.MkdirAll(blobPath, 0755); err != nil {
+		return "", fmt.Errorf("create folder failed: %v", err)
+	}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(blobPath, "layer.tar")); err != nil {
+		return "", fmt.Errorf("failed to move squashed layer: %v", err)
+	}
+
+	return diffID, nil
+}
+
+// buildSquashedConfig 基于原来的 config 生成新的 config, 只保留一个 diff_id 和一条 history, 返回新 config 的 digest
+//
+// 其他字段 (env, entrypoint, labels 等) 原样保留
+func buildSquashedConfig(cacheDir, configDigest, diffID string, layerCount int) (string, error) {
+	raw, err := os.ReadFile(configPath(cacheDir, configDigest))
+	if err != nil {
+		return "", fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var config map[string]any
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return "", fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+
+	// history 的时间使用原来 config 的 created, 同一个镜像每次 squash 得到相同的 config digest
+	history := map[string]any{
+		"created_by": "docker-pull squash",
+		"comment":    fmt.Sprintf("squashed from %d layers of sha256:%s", layerCount, configDigest),
+	}
+	if created, ok := config["created"].(string); ok && created != "" {
+		history["created"] = created
+	}
+
+	config["rootfs"] = map[string]any{
+		"type":     "layers",
+		"diff_ids": []string{"sha256:" + diffID},
+	}
+	config["history"] = []map[string]any{history}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	newDigest := hex.EncodeToString(sum[:])
+
+	if err := writeFileAtomic(configPath(cacheDir, newDigest), data); err != nil {
+		return "", fmt.Errorf("failed to write config: %v", err)
+	}
+
+	return newDigest, nil
+}
@@ -0,0 +1,91 @@
+package dockerpull
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSquash(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	layersDir := filepath.Join(cacheDir, "layers")
+	for _, d := range []string{"aaaa", "bbbb"} {
+		if err := os.MkdirAll(filepath.Join(layersDir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestLayer(t, filepath.Join(layersDir, "aaaa"), "layer.tar", []testEntry{
+		{name: "a", typeflag: tar.TypeReg, body: "a"},
+		{name: "b", typeflag: tar.TypeReg, body: "b"},
+	})
+	writeTestLayer(t, filepath.Join(layersDir, "bbbb"), "layer.tar", []testEntry{
+		{name: ".wh.a", typeflag: tar.TypeReg},
+	})
+
+	configDir := filepath.Join(cacheDir, "config", "cccc")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"architecture":"amd64","created":"2024-01-02T03:04:05Z","os":"linux","config":{"Env":["A=1"]},"rootfs":{"type":"layers","diff_ids":["sha256:1","sha256:2"]},"history":[{},{}]}`
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info := &TarInfo{ConfigDigest: "cccc", LayersDigest: []string{"aaaa", "bbbb"}, CacheDir: cacheDir}
+	if err := info.Squash(); err != nil {
+		t.Fatalf("Squash() error = %v", err)
+	}
+
+	if len(info.LayersDigest) != 1 {
+		t.Fatalf("LayersDigest = %v, want a single layer", info.LayersDigest)
+	}
+
+	raw, err := os.ReadFile(configPath(cacheDir, info.ConfigDigest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Config struct{ Env []string }
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+		History []map[string]any
+	}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.RootFS.DiffIDs) != 1 || got.RootFS.DiffIDs[0] != "sha256:"+info.LayersDigest[0] {
+		t.Errorf("diff_ids = %v, want [sha256:%s]", got.RootFS.DiffIDs, info.LayersDigest[0])
+	}
+	if len(got.History) != 1 || got.History[0]["created"] != "2024-01-02T03:04:05Z" {
+		t.Errorf("history = %v, want one entry created at 2024-01-02T03:04:05Z", got.History)
+	}
+	if len(got.Config.Env) != 1 || got.Config.Env[0] != "A=1" {
+		t.Errorf("config.Env = %v, want [A=1]", got.Config.Env)
+	}
+
+	var names []string
+	err = walkLayer(filepath.Join(layersDir, info.LayersDigest[0], "layer.tar"), func(hdr *tar.Header, _ io.Reader) error {
+		names = append(names, hdr.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "b" {
+		t.Errorf("squashed layer entries = %v, want [b]", names)
+	}
+
+	// 同一个镜像再次 squash 得到相同的 config digest
+	again := &TarInfo{ConfigDigest: "cccc", LayersDigest: []string{"aaaa", "bbbb"}, CacheDir: cacheDir}
+	if err := again.Squash(); err != nil {
+		t.Fatalf("Squash() error = %v", err)
+	}
+	if again.ConfigDigest != info.ConfigDigest {
+		t.Errorf("second Squash() config digest = %s, want %s", again.ConfigDigest, info.ConfigDigest)
+	}
+}
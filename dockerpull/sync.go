@@ -0,0 +1,176 @@
+package dockerpull
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"gopkg.in/yaml.v3"
+)
+
+// SyncConfig sync.yaml 的内容
+//
+//	destination: harbor.example.com/mirror
+//	images:
+//	  - source: nginx:~1.25
+//	  - source: redis
+//	    tags: ["7.2", "7.4"]
+//	  - source: quay.io/prometheus/prometheus
+//	    tag-regex: '^v2\.5[0-9]\.\d+$'
+//	    destination: harbor.example.com/monitoring/prometheus
+type SyncConfig struct {
+	// Destination 默认的目标前缀, 目标仓库为 <前缀>/<源仓库路径>, 如 harbor.example.com/mirror/library/nginx
+	Destination string      `yaml:"destination"`
+	Images      []SyncImage `yaml:"images"`
+}
+
+// SyncImage 一个需要同步的源仓库
+type SyncImage struct {
+	// Source 源镜像, tag 可以是版本范围, 同下载的 -image
+	Source string `yaml:"source"`
+	// Tags 指定 tag 列表, 忽略 Source 中的 tag
+	Tags []string `yaml:"tags"`
+	// TagRegex 同步所有匹配的 tag, 忽略 Source 中的 tag
+	TagRegex string `yaml:"tag-regex"`
+	// Destination 目标仓库 (不含 tag), 为空时使用 SyncConfig.Destination 前缀
+	Destination string `yaml:"destination"`
+}
+
+// LoadSyncConfig 读取并校验 sync.yaml
+func LoadSyncConfig(path string) (*SyncConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg SyncConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid sync config %s: %w", path, err)
+	}
+
+	for i, img := range cfg.Images {
+		if img.Source == "" {
+			return nil, fmt.Errorf("invalid sync config %s: images[%d] has no source", path, i)
+		}
+		if img.Destination == "" && cfg.Destination == "" {
+			return nil, fmt.Errorf("invalid sync config %s: images[%d] has no destination and there is no default destination", path, i)
+		}
+	}
+	return &cfg, nil
+}
+
+// SyncOptions Sync 的参数, 含义同 CopyOptions
+type SyncOptions struct {
+	Proxy                    *url.URL
+	SourceSystemContext      *types.SystemContext
+	DestinationSystemContext *types.SystemContext
+	Force                    bool
+	Logger                   Logger
+}
+
+// SyncPair 一个需要复制的源镜像和目标镜像
+type SyncPair struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// ResolveSync 查询需要的 tag 列表, 返回所有需要复制的镜像
+func ResolveSync(ctx context.Context, cfg *SyncConfig, sysCtx *types.SystemContext) ([]SyncPair, error) {
+	var pairs []SyncPair
+	var errs []error
+	for _, img := range cfg.Images {
+		sources, err := syncSources(ctx, img, sysCtx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, source := range sources {
+			dst, err := syncDestination(source, img.Destination, cfg.Destination)
+			if err != nil {
+				errs = append(errs, &Error{Op: "resolve destination", Ref: source, Err: err})
+				continue
+			}
+			pairs = append(pairs, SyncPair{Source: source, Destination: dst})
+		}
+	}
+	return pairs, errors.Join(errs...)
+}
+
+// syncSources 展开一个源仓库需要同步的所有镜像
+func syncSources(ctx context.Context, img SyncImage, sysCtx *types.SystemContext) ([]string, error) {
+	repo, _ := SplitTag(img.Source)
+	switch {
+	case len(img.Tags) > 0:
+		var sources []string
+		for _, tag := range img.Tags {
+			sources = append(sources, repo+":"+tag)
+		}
+		return sources, nil
+	case img.TagRegex != "":
+		return ExpandTagPattern(ctx, repo, img.TagRegex, sysCtx)
+	case HasTagPattern(img.Source):
+		return ExpandTagPattern(ctx, img.Source, "", sysCtx)
+	default:
+		return []string{img.Source}, nil
+	}
+}
+
+// syncDestination 目标镜像: 指定了目标仓库时使用目标仓库, 否则为 <前缀>/<源仓库路径>, tag 和源相同
+func syncDestination(source, repo, prefix string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(source)
+	if err != nil {
+		return "", err
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+	if !ok {
+		return "", fmt.Errorf("digest references cannot be synced, use a tag")
+	}
+	if repo == "" {
+		repo = strings.TrimSuffix(prefix, "/") + "/" + reference.Path(named)
+	}
+	return repo + ":" + tagged.Tag(), nil
+}
+
+// Sync 按配置复制所有镜像, 已经是最新的镜像跳过; 单个镜像失败时继续复制其他镜像, 最后返回所有错误
+func Sync(ctx context.Context, cfg *SyncConfig, opts SyncOptions) ([]CopyResult, error) {
+	log := loggerOrNop(opts.Logger)
+	srcSysCtx := opts.SourceSystemContext
+	if srcSysCtx == nil {
+		srcSysCtx = NewSystemContext(opts.Proxy)
+	}
+
+	pairs, err := ResolveSync(ctx, cfg, srcSysCtx)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	var results []CopyResult
+	for i, pair := range pairs {
+		log.Infof("[%d/%d] %s -> %s", i+1, len(pairs), pair.Source, pair.Destination)
+		result, err := Copy(ctx, CopyOptions{
+			Source:                   pair.Source,
+			Destination:              pair.Destination,
+			Proxy:                    opts.Proxy,
+			SourceSystemContext:      srcSysCtx,
+			DestinationSystemContext: opts.DestinationSystemContext,
+			Force:                    opts.Force,
+			Logger:                   opts.Logger,
+		})
+		if err != nil {
+			log.Errorf("Failed to copy %s: %v", pair.Source, err)
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,96 @@
+package dockerpull
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/containers/image/v5/types"
+)
+
+// tag 中不允许出现的字符, 出现时说明是版本范围而不是具体的 tag
+const tagPatternChars = "~^<>=* "
+
+// SplitTag 拆分镜像名称为仓库和 tag, 没有 tag 时返回空
+func SplitTag(image string) (string, string) {
+	repo := RepositoryName(image)
+	if repo == image {
+		return repo, ""
+	}
+	return repo, strings.TrimPrefix(image[len(repo):], ":")
+}
+
+// HasTagPattern 判断镜像名称中的 tag 是否是版本范围, 如 nginx:~1.25
+func HasTagPattern(image string) bool {
+	_, tag := SplitTag(image)
+	return strings.ContainsAny(tag, tagPatternChars)
+}
+
+// ExpandTagPattern 查询远程仓库的 tag, 返回所有匹配版本范围或正则的镜像名称, 按版本排序
+func ExpandTagPattern(ctx context.Context, image, tagRegex string, sysCtx *types.SystemContext) ([]string, error) {
+	repo, tag := SplitTag(image)
+
+	match, err := tagPatternMatcher(tag, tagRegex)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := ListTags(ctx, repo, sysCtx)
+	if err != nil {
+		return nil, &Error{Op: "list tags", Ref: repo, Err: err}
+	}
+
+	var matched []string
+	for _, t := range tags {
+		if match(t) {
+			matched = append(matched, t)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoMatchingTags, repo, strings.TrimSpace(tag+" "+tagRegex))
+	}
+	SortTagsSemVer(matched)
+
+	var images []string
+	for _, t := range matched {
+		images = append(images, fmt.Sprintf("%s:%s", repo, t))
+	}
+
+	return images, nil
+}
+
+// tagPatternMatcher 返回判断 tag 是否匹配版本范围 (tag 中有 ~^<>= 等字符时) 和正则的函数
+func tagPatternMatcher(tag, tagRegex string) (func(string) bool, error) {
+	var re *regexp.Regexp
+	if tagRegex != "" {
+		var err error
+		re, err = regexp.Compile(tagRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag regex: %w", err)
+		}
+	}
+
+	var semverRange *SemVerRange
+	if strings.ContainsAny(tag, tagPatternChars) {
+		r, ok := ParseSemVerRange(tag)
+		if !ok {
+			return nil, fmt.Errorf("invalid version range: %s", tag)
+		}
+		semverRange = &r
+	}
+
+	return func(t string) bool {
+		if re != nil && !re.MatchString(t) {
+			return false
+		}
+		if semverRange != nil {
+			v, ok := ParseSemVer(t)
+			if !ok || !semverRange.Match(v) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
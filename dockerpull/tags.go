@@ -0,0 +1,106 @@
+package dockerpull
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ListTags 调用 /v2/<name>/tags/list, 分页和鉴权由 containers/image 处理
+func ListTags(ctx context.Context, image string, sysCtx *types.SystemContext) ([]string, error) {
+	ref, _, err := ParseImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+	return docker.GetRepositoryTags(ctx, sysCtx, ref)
+}
+
+// FilterTags 返回匹配正则的 tag, re 为空时返回全部
+func FilterTags(tags []string, re *regexp.Regexp) []string {
+	if re == nil {
+		return tags
+	}
+	var matched []string
+	for _, tag := range tags {
+		if re.MatchString(tag) {
+			matched = append(matched, tag)
+		}
+	}
+	return matched
+}
+
+// ResolveTag 获取 tag 对应的 manifest digest, 如果是 manifest list 同时返回其中的平台
+func ResolveTag(ctx context.Context, image, tag string, sysCtx *types.SystemContext) (digest.Digest, []string, error) {
+	ref, _, err := ParseImageRef(WithTag(image, tag))
+	if err != nil {
+		return "", nil, err
+	}
+
+	src, err := ref.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	raw, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	d, err := manifest.Digest(raw)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var platforms []string
+	switch manifest.GuessMIMEType(raw) {
+	case manifest.DockerV2ListMediaType, ocispec.MediaTypeImageIndex:
+		var index ocispec.Index
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return d, nil, err
+		}
+		for _, m := range index.Manifests {
+			// 跳过 attestation 等非镜像 manifest
+			if m.Platform == nil || m.Platform.OS == "unknown" {
+				continue
+			}
+			platforms = append(platforms, PlatformString(m.Platform))
+		}
+	}
+
+	return d, platforms, nil
+}
+
+// WithTag 把镜像名称中的 tag 替换为 tag
+func WithTag(image, tag string) string {
+	return RepositoryName(image) + ":" + tag
+}
+
+// RepositoryName 去掉镜像名称中的 tag 和 digest, registry 端口中的冒号会保留
+func RepositoryName(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// RemoteManifestDigest 通过 HEAD 请求获取远程 manifest (或 manifest list) 的 digest
+func RemoteManifestDigest(ctx context.Context, image string, sysCtx *types.SystemContext) (string, error) {
+	ref, _, err := ParseImageRef(image)
+	if err != nil {
+		return "", err
+	}
+	d, err := docker.GetDigest(ctx, sysCtx, ref)
+	if err != nil {
+		return "", err
+	}
+	return d.String(), nil
+}
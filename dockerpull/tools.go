@@ -1,10 +1,15 @@
-package main
+package dockerpull
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/mholt/archiver/v3"
 )
@@ -28,7 +33,7 @@ func SlicesLast[T any](slice []T) T {
 	return slice[len(slice)-1]
 }
 
-func CopyFile(src, dst string) error {
+func CopyFile(log Logger, src, dst string) error {
 	input, err := os.ReadFile(src)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %v", err)
@@ -36,7 +41,7 @@ func CopyFile(src, dst string) error {
 
 	// 检查文件是否已存在
 	if FileExists(dst) {
-		Logger.Infoln("dst already exists, skipping: ", dst)
+		log.Infof("dst already exists, skipping: %s", dst)
 		return nil
 	}
 
@@ -46,13 +51,13 @@ func CopyFile(src, dst string) error {
 		return fmt.Errorf("failed to write file: %v", err)
 	}
 
-	Logger.Debugf("Successfully copied file from [%s] to [%s] \n", src, dst)
+	log.Debugf("Successfully copied file from [%s] to [%s]", src, dst)
 
 	return nil
 }
 
-func CreateTar(srcDir, tarFilePath string) error {
-	Logger.Info("开始打包目录:", srcDir)
+func CreateTar(log Logger, srcDir, tarFilePath string) error {
+	log.Infof("开始打包目录: %s", srcDir)
 	// 创建目标目录
 	destDir := filepath.Dir(tarFilePath)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
@@ -73,9 +78,9 @@ func CreateTar(srcDir, tarFilePath string) error {
 	// 打包目录为 .tar 文件
 	err = archiver.Archive(files, tarFilePath)
 	if err != nil {
-		Logger.Fatal(err)
+		return err
 	}
-	Logger.Info("package success")
+	log.Infof("package success")
 
 	return nil
 }
@@ -94,3 +99,62 @@ func WriteJsonFile(repoFile *os.File, data any) error {
 
 	return nil
 }
+
+// FileSHA256 计算文件的 sha256, 返回 hex 字符串
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParseByteSize 解析 1024, 500K, 1.5M, 10GB, 2GiB 这样的大小 (单位为 1024)
+func ParseByteSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+
+	multiplier := int64(1)
+	if v != "" {
+		switch v[len(v)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			v = v[:len(v)-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a size like 500K, 5M or 1G", s)
+	}
+	return int64(f * float64(multiplier)), nil
+}
+
+// FormatSize 格式化为 1.5MiB 这样的大小
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
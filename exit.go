@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// exitUsage 参数错误的退出码, 和 flag 包解析失败时一致; 其他退出码见 dockerpull.ErrorClass
+const exitUsage = 2
+
+// fatalError 输出错误和处理建议, 按错误分类的退出码退出
+func fatalError(err error) {
+	class := dockerpull.Classify(err)
+	Logger.Errorf("%s: %v", class, err)
+	if hint := dockerpull.Hint(err); hint != "" {
+		Logger.Warnf("hint: %s", hint)
+	}
+	os.Exit(class.ExitCode())
+}
+
+// fatalUsage 参数错误
+func fatalUsage(format string, args ...any) {
+	Logger.Errorf(format, args...)
+	os.Exit(exitUsage)
+}
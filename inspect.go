@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hwhaocool/docker-pull/dockerpull"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func runInspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+
+	var image, proxyAddr, arch, format string
+	fs.StringVar(&image, "image", "", "镜像名称, 也可以直接作为参数, 如 docker-pull inspect nginx:1.25")
+	fs.StringVar(&arch, "arch", "amd64", "cpu架构, manifest list 中按此选择 manifest")
+	fs.StringVar(&proxyAddr, "proxy", "", "代理地址, 格式同下载")
+	fs.StringVar(&format, "format", "table", "输出格式, 可选 table, json")
+	fs.Parse(args)
+
+	if image == "" {
+		image = fs.Arg(0)
+	}
+	if image == "" {
+		fatalUsage("必须提供镜像名称")
+	}
+
+	result, err := dockerpull.Inspect(context.Background(), image, arch, dockerpull.NewSystemContext(parseProxy(proxyAddr)))
+	if err != nil {
+		fatalError(fmt.Errorf("failed to inspect image: %w", err))
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fatalError(err)
+		}
+	case "table":
+		printInspectResult(os.Stdout, result)
+	default:
+		fatalUsage("Unsupported format: %s", format)
+	}
+}
+
+func printInspectResult(out io.Writer, result *dockerpull.InspectResult) {
+	fmt.Fprintf(out, "Reference:  %s\n", result.Reference)
+	fmt.Fprintf(out, "Digest:     %s\n", result.ManifestDigest)
+	fmt.Fprintf(out, "MediaType:  %s\n", result.MediaType)
+
+	if result.ManifestList != nil {
+		printManifestList(out, *result.ManifestList)
+	}
+	printManifest(out, result.Manifest)
+	printConfig(out, result.Config)
+}
+
+func printManifestList(out io.Writer, list ocispec.Index) {
+	fmt.Fprintln(out, "\n=== Manifest List ===")
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORM\tDIGEST\tSIZE\tANNOTATIONS")
+	for _, m := range list.Manifests {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", dockerpull.PlatformString(m.Platform), m.Digest, m.Size, formatMap(m.Annotations))
+	}
+	w.Flush()
+}
+
+func printManifest(out io.Writer, man ocispec.Manifest) {
+	fmt.Fprintln(out, "\n=== Manifest ===")
+	fmt.Fprintf(out, "Config:  %s (%d bytes)\n", man.Config.Digest, man.Config.Size)
+
+	var total int64
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tDIGEST\tSIZE\tMEDIATYPE")
+	for i, layer := range man.Layers {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", i+1, layer.Digest, layer.Size, layer.MediaType)
+		total += layer.Size
+	}
+	w.Flush()
+	fmt.Fprintf(out, "Total:   %d layers, %d bytes\n", len(man.Layers), total)
+}
+
+func printConfig(out io.Writer, config ocispec.Image) {
+	fmt.Fprintln(out, "\n=== Config ===")
+
+	if config.Created != nil {
+		fmt.Fprintf(out, "Created:       %s\n", config.Created.Format("2006-01-02 15:04:05 MST"))
+	}
+	fmt.Fprintf(out, "Platform:      %s\n", dockerpull.PlatformString(&config.Platform))
+	fmt.Fprintf(out, "User:          %s\n", config.Config.User)
+	fmt.Fprintf(out, "WorkingDir:    %s\n", config.Config.WorkingDir)
+	fmt.Fprintf(out, "Entrypoint:    %s\n", formatSlice(config.Config.Entrypoint))
+	fmt.Fprintf(out, "Cmd:           %s\n", formatSlice(config.Config.Cmd))
+
+	var ports []string
+	for port := range config.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	fmt.Fprintf(out, "ExposedPorts:  %s\n", strings.Join(ports, " "))
+
+	fmt.Fprintln(out, "Env:")
+	for _, env := range config.Config.Env {
+		fmt.Fprintf(out, "  %s\n", env)
+	}
+
+	fmt.Fprintln(out, "Labels:")
+	for _, kv := range sortedPairs(config.Config.Labels) {
+		fmt.Fprintf(out, "  %s\n", kv)
+	}
+
+	fmt.Fprintln(out, "\nHistory:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED\tCREATED BY\tEMPTY LAYER")
+	for _, h := range config.History {
+		created := ""
+		if h.Created != nil {
+			created = h.Created.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\n", created, truncate(h.CreatedBy, 80), h.EmptyLayer)
+	}
+	w.Flush()
+}
+
+func formatSlice(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func formatMap(m map[string]string) string {
+	return strings.Join(sortedPairs(m), ", ")
+}
+
+func sortedPairs(m map[string]string) []string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -1,59 +1,347 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/url"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/containers/image/v5/types"
 	"github.com/fatih/color"
+	"github.com/hwhaocool/docker-pull/dockerpull"
 )
 
 var Version = "dev"
 
+// 子命令, 不带子命令时默认为下载镜像
+var subcommands = map[string]func(args []string){
+	"inspect": runInspect,
+	"tags":    runTags,
+	"check":   runCheck,
+	"cache":   runCache,
+	"push":    runPush,
+	"copy":    runCopy,
+	"sync":    runSync,
+	"serve":   runServe,
+	"server":  runServer,
+}
+
 func main() {
-	color.HiMagenta("docker-pull version: %s", Version)
-	var image, proxyAddr, destination, arch string
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
+	var image, proxyAddr, arch, export, tagRegex, report string
+	var squash, force, offline, load, listOnly, frozen bool
+	var rateLimitWait time.Duration
+	var limitRate, limitRateConn string
+	var cacheDir string
+	var composeFile, envFile, bundle, k8sPath, dockerfile, lockPath string
+	buildArgs := map[string]string{}
+
+	flag.StringVar(&image, "image", "", "镜像名称, tag 可以是版本范围, 如 nginx:~1.25, nginx:^1.2, nginx:1.25.*, 'nginx:>=1.2 <1.5'; 支持如 alpine:3.22.1; nginx; library/nginx:1.20; docker.io/library/nginx:latest; myregistry.com/myproject/myapp:v1.0; myregistry.com:5000/myproject/myapp:v1.0 等格式")
 
-	flag.StringVar(&image, "image", "", "镜像名称, 支持如 alpine:3.22.1; nginx; library/nginx:1.20; docker.io/library/nginx:latest; myregistry.com/myproject/myapp:v1.0; myregistry.com:5000/myproject/myapp:v1.0 等格式")
+	flag.StringVar(&tagRegex, "tag-regex", "", "按正则匹配远程仓库的 tag, 下载所有匹配的 tag, 此时 -image 中的 tag 会被忽略")
+
+	flag.StringVar(&composeFile, "compose", "", "下载 docker-compose 文件中所有服务的镜像 (services.*.image), 代替 -image")
+
+	flag.StringVar(&envFile, "env-file", "", "-compose 替换变量使用的 env 文件, 默认为 compose 文件旁边的 .env")
+
+	flag.StringVar(&k8sPath, "k8s", "", "下载 Kubernetes manifest 中所有 Pod, Deployment, StatefulSet, DaemonSet, Job, CronJob 等引用的镜像, 可以是文件, 目录或 - (stdin, 如 helm template 的输出), 代替 -image")
+
+	flag.StringVar(&dockerfile, "dockerfile", "", "下载 Dockerfile 中所有 FROM 引用的基础镜像 (跳过阶段名称和 scratch), FROM --platform 声明的架构优先于 -arch, 代替 -image")
+
+	flag.Func("build-arg", "-dockerfile 中 ARG 的值, 格式 KEY=VALUE, 可以指定多次", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("want KEY=VALUE")
+		}
+		buildArgs[key] = value
+		return nil
+	})
+
+	flag.StringVar(&lockPath, "lock", "", "锁文件, 记录每个镜像的 manifest list, 平台 manifest 和 config 的 digest; 不带 -frozen 时下载后写入或更新")
+
+	flag.BoolVar(&frozen, "frozen", false, "严格按 -lock 中记录的 digest 下载, 即使 tag 已经指向其他镜像; 镜像不在锁文件中或 digest 不存在时报错")
+
+	flag.BoolVar(&listOnly, "list-only", false, "只输出要下载的镜像列表 (每行一个) 然后退出, 可以和 -compose, -k8s, -dockerfile 或版本范围一起使用")
+
+	flag.StringVar(&bundle, "bundle", "", "把本次下载的所有镜像合并为一个 tar, 并在旁边生成 load 脚本, 如 output/myproject.tar")
 
 	flag.StringVar(&arch, "arch", "amd64", "cpu架构, 可选 amd64, arm64, 默认 amd64")
 
 	flag.StringVar(&proxyAddr, "proxy", "", "代理地址, 支持 socks5://username:password@ip:port 或者 http://username:password@ip:port; 也支持不带认证的代理，如 socks5://ip:port 或者 http://ip:port")
 
+	flag.StringVar(&export, "export", "", "导出扁平化的根文件系统而不是可 load 的镜像, 以 .tar 结尾输出为单个 tar (类似 docker export), 否则输出为目录")
+
+	flag.BoolVar(&squash, "squash", false, "把所有 layer 合并为一个 layer 后再打包")
+
+	flag.BoolVar(&load, "load", false, "把镜像直接 load 到 Docker Engine (环境变量 DOCKER_HOST, 默认 "+dockerpull.DefaultDockerHost+"), 不在 output 目录生成 tar")
+
+	flag.StringVar(&report, "report", "", "下载完成后输出 JSON 格式的结果报告到指定文件, - 表示输出到 stdout (此时日志输出到 stderr)")
+
+	flag.BoolVar(&force, "force", false, "即使输出目录中已有和远程 manifest 一致的 tar 也重新构建")
+
+	flag.DurationVar(&rateLimitWait, "ratelimit-wait", 0, "被 registry 限流 (HTTP 429) 时最多等待多久后重试, 如 30m; 默认不等待, 直接报错")
+
+	flag.StringVar(&limitRate, "limit-rate", "", "限制下载的总速率, 所有并发下载的 blob 和本次下载的所有镜像共享, 如 500K, 5M")
+
+	flag.StringVar(&limitRateConn, "limit-rate-conn", "", "限制每个 blob 下载连接的速率, 格式同 -limit-rate")
+
+	flag.StringVar(&cacheDir, "cache-dir", dockerpull.DefaultCacheDir(), "layer 和 config 的缓存目录, 多个进程可以同时使用; 也可以用环境变量 "+dockerpull.CacheDirEnv+" 设置")
+
+	flag.BoolVar(&offline, "offline", false, "不访问 registry, 只用缓存目录中的 manifest 和 layer 构建之前在线下载过的镜像")
+
 	// TODO: 待支持
 	// flag.StringVar(&destination, "dst", "output", "镜像保存路径")
 
 	flag.Parse()
 
-	if image == "" {
-		Logger.Fatal("必须提供 -image 参数")
+	// 报告或镜像列表输出到 stdout 时, 其他输出都改到 stderr, 保证 stdout 可以直接被其他程序使用
+	if report == "-" || listOnly {
+		Logger.SetOutput(os.Stderr)
+		color.Output = os.Stderr
+	}
+
+	color.HiMagenta("docker-pull version: %s", Version)
+	startedAt := time.Now()
+
+	switch sources := countNonEmpty(image, composeFile, k8sPath, dockerfile); {
+	case sources == 0:
+		fatalUsage("必须提供 -image, -compose, -k8s 或 -dockerfile 参数")
+	case sources > 1:
+		fatalUsage("-image, -compose, -k8s 和 -dockerfile 不能同时使用")
+	}
+	if tagRegex != "" && image == "" {
+		fatalUsage("-tag-regex 只能和 -image 一起使用")
+	}
+	if frozen && lockPath == "" {
+		fatalUsage("-frozen 需要 -lock 参数")
+	}
+	if lockPath != "" && squash {
+		fatalUsage("-lock 不能和 -squash 同时使用")
+	}
+	if bundle != "" && (export != "" || load) {
+		fatalUsage("-bundle 不能和 -export, -load 同时使用")
+	}
+
+	ctx := context.Background()
+	opts := dockerpull.Options{
+		Image:  image,
+		Proxy:  parseProxy(proxyAddr),
+		Arch:   arch,
+		Export: export,
+		Squash: squash,
+		Force:  force,
+		Logger: Logger,
+
+		CacheDir:      cacheDir,
+		Offline:       offline,
+		RateLimitWait: rateLimitWait,
+		Bandwidth:     dockerpull.NewBandwidthLimiter(parseRate("limit-rate", limitRate)),
+		ConnRate:      parseRate("limit-rate-conn", limitRateConn),
 	}
 
-	var proxyURL *url.URL
-	var err error
-	if proxyAddr != "" {
-		// 解析 proxy URL
-		proxyURL, err = url.Parse(proxyAddr)
+	if load {
+		if export != "" {
+			fatalUsage("-load 和 -export 不能同时使用")
+		}
+		client, err := dockerpull.NewEngineClient("")
 		if err != nil {
-			Logger.Fatal("proxy参数格式错误", err)
+			fatalUsage("%v", err)
 		}
+		opts.Load = client
 	}
 
-	cmd := Cmd{
-		image:       image,
-		proxy:       proxyURL,
-		destination: destination,
-		arch:        arch,
+	lock := openLockFile(lockPath, frozen)
+
+	images := []string{image}
+	// imageArchs 不为空时为每个镜像的架构, 代替 -arch
+	var imageArchs []string
+
+	if composeFile != "" {
+		images = composeImages(composeFile, envFile)
+	} else if k8sPath != "" {
+		images = kubernetesImages(k8sPath)
+	} else if dockerfile != "" {
+		images, imageArchs = dockerfileImages(dockerfile, arch, buildArgs)
+	} else if tagRegex != "" || dockerpull.HasTagPattern(image) {
+		// tag 是版本范围 (如 nginx:~1.25) 或者指定了 -tag-regex 时, 下载所有匹配的 tag
+		if offline && !frozen {
+			fatalUsage("-offline 不支持版本范围和 -tag-regex (除非和 -frozen 一起使用), 需要查询远程仓库的 tag")
+		}
+		opts.SkipExisting = true
+
+		var err error
+		if frozen {
+			// -frozen 时只下载锁文件中匹配的 tag, 不查询远程仓库, 之后发布的 tag 不影响结果
+			images, err = lock.MatchTagPattern(image, tagRegex, arch)
+		} else {
+			images, err = dockerpull.ExpandTagPattern(ctx, image, tagRegex, dockerpull.NewSystemContext(opts.Proxy))
+		}
+		if err != nil {
+			fatalError(err)
+		}
+
+		color.HiCyan("Matched %d tags:", len(images))
+		for _, img := range images {
+			color.HiCyan("  %s", img)
+		}
 	}
 
-	DownloadImage(cmd)
+	if listOnly {
+		for i, img := range images {
+			if imageArchs != nil && imageArchs[i] != arch {
+				fmt.Printf("%s\t%s\n", img, imageArchs[i])
+			} else {
+				fmt.Println(img)
+			}
+		}
+		return
+	}
+
+	// 批量下载 (-compose, -k8s, -dockerfile 或版本范围) 前显示剩余的 pull 次数
+	batch := image == "" || tagRegex != "" || dockerpull.HasTagPattern(image)
+	if batch && !offline {
+		printRateLimit(ctx, images, dockerpull.NewSystemContext(opts.Proxy))
+	}
+
+	var results []dockerpull.Result
+	for i, img := range images {
+		_, info, err := dockerpull.ParseImageRef(img)
+		if err != nil {
+			fatalError(err)
+		}
+		printImageInfo(info)
+
+		opts.Image = img
+		if imageArchs != nil {
+			opts.Arch = imageArchs[i]
+		}
+		if frozen {
+			pin, ok := lock.Find(img, opts.Arch)
+			if !ok {
+				fatalError(fmt.Errorf("%s (%s) is not in lock file %s, run without -frozen to add it", img, opts.Arch, lockPath))
+			}
+			opts.Pin = &pin
+		}
+		result, err := dockerpull.Pull(ctx, opts)
+		if err != nil {
+			fatalError(err)
+		}
+		results = append(results, result)
+
+		if lock != nil && !frozen {
+			lock.Set(dockerpull.LockedImageFromResult(img, result))
+		}
+	}
+
+	if lock != nil && !frozen {
+		if err := lock.Write(lockPath); err != nil {
+			fatalError(fmt.Errorf("failed to write lock file: %w", err))
+		}
+		color.HiMagenta("Locked %d images in %s", len(lock.Images), lockPath)
+	}
+
+	if bundle != "" {
+		writeBundle(bundle, results)
+	}
+
+	if report != "" {
+		err := dockerpull.WriteReport(report, dockerpull.RunReport{
+			Version:    Version,
+			Images:     results,
+			StartedAt:  startedAt,
+			DurationMs: time.Since(startedAt).Milliseconds(),
+		})
+		if err != nil {
+			fatalError(err)
+		}
+	}
+
+	fmt.Fprintln(color.Output, "ok")
+}
+
+// openLockFile 读取锁文件, 不存在时 (第一次运行) 返回空的锁文件; -frozen 时锁文件必须存在
+func openLockFile(path string, frozen bool) *dockerpull.LockFile {
+	if path == "" {
+		return nil
+	}
+	lock, err := dockerpull.ReadLockFile(path)
+	if errors.Is(err, os.ErrNotExist) && !frozen {
+		return &dockerpull.LockFile{}
+	}
+	if err != nil {
+		fatalError(err)
+	}
+	return lock
+}
+
+func countNonEmpty(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// printRateLimit 批量下载前显示剩余的 pull 次数 (Docker Hub 匿名下载有次数限制), registry 没有返回时不显示
+func printRateLimit(ctx context.Context, images []string, sysCtx *types.SystemContext) {
+	limit, err := dockerpull.CheckRateLimit(ctx, images[0], sysCtx)
+	if !limit.Known() {
+		if err != nil {
+			Logger.Debugf("Failed to check rate limit: %v", err)
+		}
+		return
+	}
+
+	color.HiCyan("Rate limit: %s pulls remaining", limit)
+	if limit.Remaining < len(images) {
+		Logger.Warnf("Only %d pulls remaining but %d images to pull, some of them may be rate limited; consider -ratelimit-wait", limit.Remaining, len(images))
+	}
+}
+
+// parseRate 解析限速参数, 为空时返回 0 (不限速)
+func parseRate(name, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	rate, err := dockerpull.ParseByteRate(value)
+	if err != nil {
+		fatalUsage("%s 参数格式错误: %v", name, err)
+	}
+	return rate
+}
 
-	fmt.Println("ok")
+// parseProxy 解析 -proxy 参数, 为空时返回 nil
+func parseProxy(proxyAddr string) *url.URL {
+	if proxyAddr == "" {
+		return nil
+	}
+
+	// 解析 proxy URL
+	proxyURL, err := url.Parse(proxyAddr)
+	if err != nil {
+		fatalUsage("proxy参数格式错误: %v", err)
+	}
+	return proxyURL
 }
 
-type Cmd struct {
-	image       string
-	proxy       *url.URL
-	destination string
-	arch        string // cpu架构, 可选 amd64, arm64
+func printImageInfo(info dockerpull.DockerImageV2) {
+	color.HiCyan("Image Info:")
+	color.HiCyan("  Domain: %s", info.Domain)
+	color.HiCyan("  Path: %s", info.Path)
+	color.HiCyan("  Tag: %s", info.Tag)
+	color.HiCyan("  Name: %s", info.Name)
+	color.HiCyan("  Namespace: %s", info.Namespace)
+	color.HiCyan("  Repository: %s", info.Repository)
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/types"
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// runPush 把 docker-archive tar 或 OCI layout 目录推送到 registry
+func runPush(args []string) {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	proxyAddr := fs.String("proxy", "", "代理地址, 格式同下载")
+	creds := fs.String("creds", "", "registry 的用户名和密码, 格式 user:password; 为空时使用 docker login 保存的凭据")
+	insecure := fs.Bool("insecure", false, "不校验 registry 的 TLS 证书")
+	cacheDir := fs.String("cache-dir", dockerpull.DefaultCacheDir(), "缓存目录, 压缩 layer 的临时文件放在这里")
+	format := fs.String("format", "table", "输出格式, 可选 table, json")
+	positional := parseInterspersed(fs, args)
+
+	if len(positional) != 2 {
+		fatalUsage("用法: docker-pull push [参数] <tar 文件或 OCI layout 目录> <目标镜像>")
+	}
+
+	result, err := dockerpull.Push(context.Background(), dockerpull.PushOptions{
+		Source:        positional[0],
+		Destination:   positional[1],
+		SystemContext: newSystemContext("creds", *proxyAddr, *creds, *insecure),
+		CacheDir:      *cacheDir,
+		Logger:        Logger,
+	})
+	if err != nil {
+		fatalError(fmt.Errorf("failed to push image: %w", err))
+	}
+
+	switch *format {
+	case "json":
+		writeJSON(result)
+	case "table":
+		fmt.Printf("Pushed %s\n", result.Destination)
+		fmt.Printf("Digest:   %s\n", result.ManifestDigest)
+		fmt.Printf("Uploaded: %d blobs (%s), %d already existed\n", result.Uploaded, dockerpull.FormatSize(result.UploadedBytes), result.Skipped)
+	default:
+		fatalUsage("Unsupported format: %s", *format)
+	}
+}
+
+// newSystemContext 根据代理, 鉴权和 TLS 参数创建 SystemContext, credsFlag 是参数名, 用于错误提示
+func newSystemContext(credsFlag, proxyAddr, creds string, insecure bool) *types.SystemContext {
+	sysCtx := dockerpull.NewSystemContext(parseProxy(proxyAddr))
+	if creds != "" {
+		user, password, ok := strings.Cut(creds, ":")
+		if !ok {
+			fatalUsage("%s 参数格式错误, 应为 user:password", credsFlag)
+		}
+		sysCtx.DockerAuthConfig = &types.DockerAuthConfig{Username: user, Password: password}
+	}
+	if insecure {
+		sysCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+	return sysCtx
+}
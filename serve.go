@@ -0,0 +1,51 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// runServe 把 cache 作为只读 registry, 内网的机器可以直接 docker pull; -pull-through 时作为上游 registry 的缓存代理
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":5000", "监听地址")
+	cacheDir := fs.String("cache-dir", dockerpull.DefaultCacheDir(), "缓存目录, 同下载的 -cache-dir")
+	pullThrough := fs.Bool("pull-through", false, "代理模式: cache 中没有的镜像从上游 registry 获取并保存到 cache")
+	upstream := fs.String("upstream", "", "代理模式下仓库名称不带域名时的上游 registry, 默认 Docker Hub")
+	tagTTL := fs.Duration("tag-ttl", dockerpull.DefaultTagTTL, "代理模式下 tag 指向的 digest 的缓存时间, 过期后向上游确认")
+	proxyAddr := fs.String("proxy", "", "访问上游使用的代理地址, 格式同下载")
+	creds := fs.String("creds", "", "上游 registry 的用户名和密码, 格式 user:password; 为空时使用 docker login 保存的凭据")
+	insecure := fs.Bool("insecure", false, "不校验上游 registry 的 TLS 证书")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fatalUsage("用法: docker-pull serve [-addr :5000] [-cache-dir 缓存目录] [-pull-through [-upstream registry] [-tag-ttl 5m]]")
+	}
+
+	handler := dockerpull.NewRegistryHandler(*cacheDir, Logger)
+	if *pullThrough {
+		handler = dockerpull.NewProxyHandler(dockerpull.ProxyOptions{
+			CacheDir:      *cacheDir,
+			Upstream:      *upstream,
+			SystemContext: newSystemContext("creds", *proxyAddr, *creds, *insecure),
+			TagTTL:        *tagTTL,
+			Logger:        Logger,
+		})
+		Logger.Infof("Serving %s as a pull-through cache of %s on %s", *cacheDir, cmp.Or(*upstream, "docker.io"), *addr)
+	} else {
+		Logger.Infof("Serving %s as a read-only registry on %s", *cacheDir, *addr)
+	}
+	if _, port, err := net.SplitHostPort(*addr); err == nil {
+		Logger.Infof("Pull with: docker pull <host>:%s/library/nginx:1.25 (add the address to insecure-registries for non-localhost clients)", port)
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: handler}
+	if err := srv.ListenAndServe(); err != nil {
+		fatalError(fmt.Errorf("failed to serve registry: %w", err))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"github.com/hwhaocool/docker-pull/dockerpull"
+)
+
+// runServer 任务服务: 通过 REST API 或网页提交下载任务, 完成后下载 tar
+func runServer(args []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "监听地址; API 没有鉴权, 监听其他地址时任何能访问的人都可以用本机的 registry 凭据下载镜像")
+	workers := fs.Int("workers", 1, "同时运行的任务数")
+	queueSize := fs.Int("queue", 16, "等待中的任务数上限, 超过时提交任务返回 503")
+	history := fs.String("history", filepath.Join("output", "jobs.json"), "保存任务历史的文件, 为空时不保存")
+	proxyAddr := fs.String("proxy", "", "代理地址, 格式同下载")
+	cacheDir := fs.String("cache-dir", dockerpull.DefaultCacheDir(), "缓存目录, 同下载的 -cache-dir")
+	limitRate := fs.String("limit-rate", "", "限制所有任务下载的总速率, 如 5M")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fatalUsage("用法: docker-pull server [-addr 127.0.0.1:8080] [-workers 1] [-queue 16] [-history output/jobs.json]")
+	}
+
+	queue, err := dockerpull.NewJobQueue(dockerpull.JobQueueOptions{
+		Workers:     *workers,
+		QueueSize:   *queueSize,
+		HistoryFile: *history,
+		Pull: dockerpull.Options{
+			Proxy:     parseProxy(*proxyAddr),
+			CacheDir:  *cacheDir,
+			Bandwidth: dockerpull.NewBandwidthLimiter(parseRate("limit-rate", *limitRate)),
+			Logger:    Logger,
+		},
+	})
+	if err != nil {
+		fatalError(err)
+	}
+	queue.Start(context.Background())
+
+	Logger.Infof("Serving the job API on %s", *addr)
+	if host, port, err := net.SplitHostPort(*addr); err == nil {
+		if !isLoopback(host) {
+			Logger.Warnf("The job API has no authentication, anyone who can reach %s can pull images with the registry credentials of this machine", *addr)
+		}
+		Logger.Infof("Open http://localhost:%s/ to submit jobs", port)
+	}
+	srv := &http.Server{Addr: *addr, Handler: dockerpull.NewJobHandler(queue)}
+	if err := srv.ListenAndServe(); err != nil {
+		fatalError(fmt.Errorf("failed to serve job API: %w", err))
+	}
+}
+
+// isLoopback 监听地址是否只能从本机访问, 为空时监听所有地址
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
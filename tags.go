@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hwhaocool/docker-pull/dockerpull"
+	"github.com/opencontainers/go-digest"
+)
+
+// TagInfo tags 子命令输出的一个 tag, Digest 和 Platforms 只有 -resolve 时才有
+type TagInfo struct {
+	Tag       string        `json:"tag"`
+	Digest    digest.Digest `json:"digest,omitempty"`
+	Platforms []string      `json:"platforms,omitempty"`
+}
+
+func runTags(args []string) {
+	fs := flag.NewFlagSet("tags", flag.ExitOnError)
+
+	var image, proxyAddr, filter, sortBy, format string
+	var resolve bool
+	fs.StringVar(&image, "image", "", "镜像名称, tag 会被忽略, 也可以直接作为参数, 如 docker-pull tags nginx")
+	fs.StringVar(&proxyAddr, "proxy", "", "代理地址, 格式同下载")
+	fs.StringVar(&filter, "filter", "", "按正则过滤 tag, 如 '^1\\.25\\.'")
+	fs.StringVar(&sortBy, "sort", "semver", "排序方式, 可选 semver, name, none (registry 返回的顺序)")
+	fs.BoolVar(&resolve, "resolve", false, "查询每个 tag 的 digest 和平台, tag 多的时候比较慢")
+	fs.StringVar(&format, "format", "table", "输出格式, 可选 table, json")
+	fs.Parse(args)
+
+	if image == "" {
+		image = fs.Arg(0)
+	}
+	if image == "" {
+		fatalUsage("必须提供镜像名称")
+	}
+
+	var re *regexp.Regexp
+	if filter != "" {
+		var err error
+		re, err = regexp.Compile(filter)
+		if err != nil {
+			fatalUsage("filter 参数格式错误: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	sysCtx := dockerpull.NewSystemContext(parseProxy(proxyAddr))
+
+	tags, err := dockerpull.ListTags(ctx, image, sysCtx)
+	if err != nil {
+		fatalError(fmt.Errorf("failed to list tags: %w", err))
+	}
+
+	tags = dockerpull.FilterTags(tags, re)
+
+	switch sortBy {
+	case "semver":
+		dockerpull.SortTagsSemVer(tags)
+	case "name":
+		sort.Strings(tags)
+	case "none":
+	default:
+		fatalUsage("Unsupported sort: %s", sortBy)
+	}
+
+	var infos []TagInfo
+	for _, tag := range tags {
+		info := TagInfo{Tag: tag}
+		if resolve {
+			info.Digest, info.Platforms, err = dockerpull.ResolveTag(ctx, image, tag, sysCtx)
+			if err != nil {
+				Logger.Warnf("Failed to resolve tag %s: %v", tag, err)
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(infos); err != nil {
+			fatalError(err)
+		}
+	case "table":
+		if !resolve {
+			for _, info := range infos {
+				fmt.Println(info.Tag)
+			}
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TAG\tDIGEST\tPLATFORMS")
+		for _, info := range infos {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", info.Tag, info.Digest, strings.Join(info.Platforms, ","))
+		}
+		w.Flush()
+	default:
+		fatalUsage("Unsupported format: %s", format)
+	}
+}